@@ -2,83 +2,424 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
+	"github.com/issy20/go-websocket/apierror"
 	"github.com/issy20/go-websocket/auth"
+	"github.com/issy20/go-websocket/config"
+	"github.com/issy20/go-websocket/models"
 	"github.com/issy20/go-websocket/repository"
+	"github.com/issy20/go-websocket/webhook"
 )
 
 type LoginUser struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	// Cookie asks for the access token in an HttpOnly cookie as well.
+	Cookie bool `json:"cookie"`
 }
 
 type UserInput struct {
 	Name     string `json:"name"`
 	Username string `json:"username"`
+	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
+type GuestInput struct {
+	Name string `json:"name"`
+}
+
+type RefreshInput struct {
+	RefreshToken string `json:"refresh_token"`
+	Cookie       bool   `json:"cookie"`
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
 type API struct {
-	UserRepository *repository.UserRepository
+	UserRepository            *repository.UserRepository
+	AuthSessionRepository     models.AuthSessionRepository
+	UserIdentityRepository    models.UserIdentityRepository
+	OIDCProvider              *auth.OIDCProvider
+	PasswordResetRepository   models.PasswordResetRepository
+	AuditRepository           models.AuditRepository
+	APIKeyRepository          models.APIKeyRepository
+	TOTPRepository            models.TOTPRepository
+	WebhookRepository         models.WebhookRepository
+	Webhooks                  *webhook.Dispatcher
+	IncomingWebhookRepository models.IncomingWebhookRepository
+	// IncomingWebhookLimiter rate limits each incoming webhook.
+	IncomingWebhookLimiter *webhook.RateLimiter
+	MailSender             models.MailSender
+	AuthConfig             *config.AuthConfig
+	PasswordConfig         *config.PasswordConfig
+	LoginConfig            *config.LoginConfig
+	UserThrottle           *auth.Throttle
+	IPThrottle             *auth.Throttle
+	Hub                    *Hub
 }
 
 func (api *API) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return
+	}
 	var user LoginUser
 
 	err := json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	ip := api.clientIP(r)
+	if wait := api.loginWait(user.Username, ip); wait > 0 {
+		writeRetryAfter(w, r, wait, http.StatusTooManyRequests)
 		return
 	}
 	dbUser := api.UserRepository.FindUserByUsername(user.Username)
 	if dbUser == nil {
-		returnErrorResponse(w)
+		if errors.Is(auth.CompareDummyPassword(user.Password), auth.ErrHashBusy) {
+			writeRetryAfter(w, r, time.Second, http.StatusServiceUnavailable)
+			return
+		}
+		api.loginFailed(user.Username, "", ip)
+		apierror.Write(w, r, http.StatusUnauthorized, "invalid username or password")
 		return
 	}
 
 	ok, err := auth.ComparePassword(user.Password, dbUser.Password)
-
+	if errors.Is(err, auth.ErrHashBusy) {
+		writeRetryAfter(w, r, time.Second, http.StatusServiceUnavailable)
+		return
+	}
 	if !ok || err != nil {
-		returnErrorResponse(w)
+		api.loginFailed(user.Username, dbUser.Id, ip)
+		apierror.Write(w, r, http.StatusUnauthorized, "invalid username or password")
 		return
 	}
+	if auth.NeedsRehash(dbUser.Password) {
+		if err := api.setPassword(dbUser, user.Password); err != nil {
+			log.Println(err, "HandleLogin()")
+		}
+	}
 
-	token, err := auth.CreateJWTToken(dbUser)
+	totp, err := api.TOTPRepository.FindTOTP(dbUser.Id)
 	if err != nil {
-		returnErrorResponse(w)
+		log.Println(err, "HandleLogin()")
+		apierror.Status(w, r, http.StatusInternalServerError)
 		return
 	}
+	// Failures are only forgotten once the second factor is through, so
+	// that knowing the password does not allow unlimited code guesses.
+	if totp != nil && totp.Enabled {
+		api.writeMFAChallenge(w, r, dbUser)
+		return
+	}
+	api.loginSucceeded(user.Username)
 
-	w.Write([]byte(token))
+	sessionID := uuid.New().String()
+	if err := api.AuthSessionRepository.CreateSession(sessionID, dbUser.GetId()); err != nil {
+		log.Println(err, "HandleLogin()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	api.writeTokens(w, r, dbUser, sessionID, user.Cookie)
 }
 
-func (api *API) HandleAddUser(w http.ResponseWriter, r *http.Request) {
-	var userInput *UserInput
-	err := json.NewDecoder(r.Body).Decode(&userInput)
+// HandleRefresh exchanges a refresh token for a new access and refresh token.
+// Each refresh token works once; presenting a used one revokes its session
+// because the token has probably been stolen.
+func (api *API) HandleRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	var input RefreshInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sessionID, userID, err := api.AuthSessionRepository.UseRefreshToken(auth.HashRefreshToken(input.RefreshToken))
+	if errors.Is(err, models.ErrRefreshTokenReused) {
+		log.Printf("refresh token reused, revoking session %s", sessionID)
+		api.revokeSession(sessionID, userID)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Status(w, r, http.StatusUnauthorized)
 		return
 	}
-	hashedPassword, err := auth.GeneratePassword(userInput.Password)
 
+	user := api.UserRepository.FindUserById(userID)
+	if user == nil {
+		apierror.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+	api.writeTokens(w, r, user, sessionID, input.Cookie)
+}
+
+// HandleLogout revokes the session of the given refresh token, which also
+// closes the WebSocket connections opened with its access tokens.
+func (api *API) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	var input RefreshInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sessionID, userID, err := api.AuthSessionRepository.FindSessionByRefreshToken(auth.HashRefreshToken(input.RefreshToken))
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		apierror.Status(w, r, http.StatusUnauthorized)
 		return
 	}
+	if err := api.revokeSession(sessionID, userID); err != nil {
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	auth.ClearTokenCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	id := uuid.New().String()
-	name := userInput.Name
-	username := userInput.Username
-	password := hashedPassword
+func (api *API) revokeSession(sessionID string, userID string) error {
+	if err := api.AuthSessionRepository.RevokeSession(sessionID); err != nil {
+		log.Println(err, "revokeSession()")
+		return err
+	}
+	api.Hub.PublishSessionRevoked(&repository.User{Id: userID}, sessionID)
+	return nil
+}
 
-	if err := api.UserRepository.AddUser(id, name, username, password); err != nil {
+func (api *API) writeTokens(w http.ResponseWriter, r *http.Request, user models.IUser, sessionID string, cookie bool) {
+	accessToken, err := auth.CreateJWTToken(user, sessionID)
+	if err != nil {
+		apierror.Status(w, r, http.StatusInternalServerError)
 		return
 	}
+	refreshToken, err := auth.IssueRefreshToken(sessionID)
+	if err != nil {
+		log.Println(err, "writeTokens()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	if cookie {
+		auth.SetTokenCookie(w, accessToken)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(auth.AccessTokenTTL().Seconds()),
+	})
 }
 
-func returnErrorResponse(w http.ResponseWriter) {
+// validateUserInput returns what is wrong with each field of a new account.
+// Usernames cannot contain ":", which is reserved for accounts created from
+// OIDC logins and for service accounts.
+func validateUserInput(input *UserInput) []apierror.FieldError {
+	var fields []apierror.FieldError
+	if !validName(strings.TrimSpace(input.Name)) {
+		fields = append(fields, apierror.FieldError{Field: "name", Message: "must be between 1 and 255 characters"})
+	}
+	switch {
+	case input.Username == "" || utf8.RuneCountInString(input.Username) > maxNameLength:
+		fields = append(fields, apierror.FieldError{Field: "username", Message: "must be between 1 and 255 characters"})
+	case strings.ContainsAny(input.Username, ": \t\r\n"):
+		fields = append(fields, apierror.FieldError{Field: "username", Message: "must not contain spaces or colons"})
+	}
+	if input.Email != "" {
+		if address, err := mail.ParseAddress(input.Email); err != nil || address.Address != input.Email || len(input.Email) > maxNameLength {
+			fields = append(fields, apierror.FieldError{Field: "email", Message: "must be a valid email address"})
+		}
+	}
+	if err := auth.CheckPassword(input.Password, input.Username); err != nil {
+		fields = append(fields, apierror.FieldError{Field: "password", Message: err.Error()})
+	}
+	return fields
+}
+
+// addUser stores a validated account and answers 409 when the username is
+// taken. It reports whether the account was created.
+func (api *API) addUser(w http.ResponseWriter, r *http.Request, user *repository.User, password string) bool {
+	hashedPassword, err := auth.GeneratePassword(password)
+	if err != nil {
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return false
+	}
+	err = api.UserRepository.AddUser(user.Id, user.Name, user.Username, user.Email, hashedPassword)
+	if errors.Is(err, models.ErrDuplicateUsername) {
+		apierror.Write(w, r, http.StatusConflict, err.Error())
+		return false
+	}
+	if err != nil {
+		log.Println(err, "addUser()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// HandleAddUser registers an account and returns it with 201.
+func (api *API) HandleAddUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	var userInput UserInput
+	if err := json.NewDecoder(r.Body).Decode(&userInput); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if fields := validateUserInput(&userInput); len(fields) > 0 {
+		apierror.WriteFields(w, r, fields)
+		return
+	}
+
+	user := &repository.User{Id: uuid.New().String(), Name: strings.TrimSpace(userInput.Name), Username: userInput.Username, Email: userInput.Email}
+	if !api.addUser(w, r, user, userInput.Password) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newUserResponse(user))
+}
+
+// HandleGuest issues a guest token, which keeps the guest's ID and name
+// across reconnects.
+func (api *API) HandleGuest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	if !api.AuthConfig.GuestsEnabled {
+		apierror.Write(w, r, http.StatusForbidden, "guests are disabled")
+		return
+	}
+	var input GuestInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	token, err := auth.CreateGuestToken(auth.NewGuest(input.Name))
+	if err != nil {
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte("{\"status\": \"error\"}"))
+	json.NewEncoder(w).Encode(&TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(api.AuthConfig.GuestTokenTTL.Seconds()),
+	})
+}
+
+// HandleUpgradeGuest turns the authenticated guest into a registered user
+// with the same ID, so that its rooms and sessions carry over, and logs it in.
+// The guest's tokens and connections stop working.
+func (api *API) HandleUpgradeGuest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	guest, ok := r.Context().Value(auth.UserContextKey).(models.IUser)
+	if !ok || !models.IsGuest(guest) {
+		apierror.Write(w, r, http.StatusForbidden, "only guests can be upgraded")
+		return
+	}
+	var userInput UserInput
+	if err := json.NewDecoder(r.Body).Decode(&userInput); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if api.UserRepository.FindUserById(guest.GetId()) != nil {
+		apierror.Write(w, r, http.StatusConflict, "guest already upgraded")
+		return
+	}
+	if fields := validateUserInput(&userInput); len(fields) > 0 {
+		apierror.WriteFields(w, r, fields)
+		return
+	}
+
+	user := &repository.User{Id: guest.GetId(), Name: strings.TrimSpace(userInput.Name), Username: userInput.Username, Email: userInput.Email}
+	if !api.addUser(w, r, user, userInput.Password) {
+		return
+	}
+	// The guest tokens stop validating now that the ID is registered. Guest
+	// connections carry no login session, so this closes them and only them.
+	api.Hub.PublishSessionRevoked(guest, "")
+
+	sessionID := uuid.New().String()
+	if err := api.AuthSessionRepository.CreateSession(sessionID, user.GetId()); err != nil {
+		log.Println(err, "HandleUpgradeGuest()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	api.writeTokens(w, r, user, sessionID, false)
+}
+
+type DirectMessagesInput struct {
+	// Allow is "anyone", "registered" or "nobody".
+	Allow string `json:"allow"`
+}
+
+// HandleDirectMessages returns on GET and changes on PUT who may start
+// direct messages with the authenticated user.
+func (api *API) HandleDirectMessages(w http.ResponseWriter, r *http.Request) {
+	user, ok := accountOwner(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		allow, err := api.UserRepository.FindDirectMessagePolicy(user.GetId())
+		if err != nil {
+			log.Println(err, "HandleDirectMessages()")
+			apierror.Status(w, r, http.StatusInternalServerError)
+			return
+		}
+		if allow == "" {
+			allow = api.Hub.ClientConfig.DirectMessages
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&DirectMessagesInput{Allow: allow})
+	case http.MethodPut:
+		var input DirectMessagesInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !models.ValidAllow(input.Allow) {
+			apierror.WriteField(w, r, "allow", "must be anyone, registered or nobody")
+			return
+		}
+		if err := api.UserRepository.SetDirectMessagePolicy(user.GetId(), input.Allow); err != nil {
+			log.Println(err, "HandleDirectMessages()")
+			apierror.Status(w, r, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+	}
 }
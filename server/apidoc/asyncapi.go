@@ -0,0 +1,70 @@
+package apidoc
+
+// Directions of an Action.
+const (
+	// FromClient actions are sent by clients to the server.
+	FromClient = "client"
+	// FromServer actions are sent by the server to clients.
+	FromServer = "server"
+)
+
+// Action documents one WebSocket action. Every frame has the schema of the
+// envelope passed to AsyncAPI; Fields names the envelope fields the action
+// uses besides "action".
+type Action struct {
+	Name      string
+	Direction string
+	Summary   string
+	Fields    []string
+}
+
+// AsyncAPI returns an AsyncAPI 2.6 document of the WebSocket endpoint at
+// path. envelope is the message type every frame is encoded as, and
+// discriminator is its field holding the action name.
+func AsyncAPI(title string, version string, path string, schemas *Schemas, envelope interface{}, discriminator string, actions []Action) map[string]interface{} {
+	envelopeSchema := schemas.Of(envelope)
+	messages := make(map[string]interface{})
+	var fromClient, fromServer []map[string]string
+	for _, action := range actions {
+		required := append([]string{discriminator}, action.Fields...)
+		// An action may be sent in both directions with different fields.
+		key := action.Direction + "." + action.Name
+		messages[key] = map[string]interface{}{
+			"name":        action.Name,
+			"summary":     action.Summary,
+			"contentType": "application/json",
+			"payload": Schema{
+				"allOf": []Schema{
+					envelopeSchema,
+					{
+						"required":   required,
+						"properties": map[string]interface{}{discriminator: Schema{"type": "string", "enum": []string{action.Name}}},
+					},
+				},
+			},
+		}
+		ref := map[string]string{"$ref": "#/components/messages/" + key}
+		if action.Direction == FromClient {
+			fromClient = append(fromClient, ref)
+		} else {
+			fromServer = append(fromServer, ref)
+		}
+	}
+
+	return map[string]interface{}{
+		"asyncapi":           "2.6.0",
+		"info":               map[string]interface{}{"title": title, "version": version},
+		"defaultContentType": "application/json",
+		"channels": map[string]interface{}{
+			path: map[string]interface{}{
+				// In AsyncAPI 2 terms, clients publish what the server receives.
+				"publish":   map[string]interface{}{"message": map[string]interface{}{"oneOf": fromClient}},
+				"subscribe": map[string]interface{}{"message": map[string]interface{}{"oneOf": fromServer}},
+			},
+		},
+		"components": map[string]interface{}{
+			"messages": messages,
+			"schemas":  schemas.Components(),
+		},
+	}
+}
@@ -0,0 +1,117 @@
+package apidoc
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Param is a query parameter of an Operation.
+type Param struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+// Operation documents one method of an HTTP endpoint. Request and Response
+// are example values of the body types, or nil when there is no body.
+// Path parameters are written as {name}.
+type Operation struct {
+	Method  string
+	Path    string
+	Summary string
+	// Auth is true for operations that need an access token or API key.
+	Auth     bool
+	Query    []Param
+	Request  interface{}
+	Status   int
+	Response interface{}
+	// Errors are the error statuses the operation answers with, each with
+	// the body of errorBody.
+	Errors []int
+}
+
+// OpenAPI returns an OpenAPI 3.0 document of operations. Every error
+// response has the schema of errorBody.
+func OpenAPI(title string, version string, schemas *Schemas, errorBody interface{}, operations []Operation) map[string]interface{} {
+	errorSchema := schemas.Of(errorBody)
+	paths := make(map[string]map[string]interface{})
+	for _, op := range operations {
+		item, ok := paths[op.Path]
+		if !ok {
+			item = make(map[string]interface{})
+			paths[op.Path] = item
+		}
+
+		operation := map[string]interface{}{"summary": op.Summary}
+		var parameters []map[string]interface{}
+		for _, segment := range strings.Split(op.Path, "/") {
+			if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+				parameters = append(parameters, map[string]interface{}{
+					"name":     strings.Trim(segment, "{}"),
+					"in":       "path",
+					"required": true,
+					"schema":   Schema{"type": "string"},
+				})
+			}
+		}
+		for _, param := range op.Query {
+			parameters = append(parameters, map[string]interface{}{
+				"name":        param.Name,
+				"in":          "query",
+				"description": param.Description,
+				"schema":      Schema{"type": param.Type},
+			})
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		if op.Request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(schemas.Of(op.Request)),
+			}
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]interface{}{"description": http.StatusText(status)}
+		if op.Response != nil {
+			success["content"] = jsonContent(schemas.Of(op.Response))
+		}
+		responses := map[string]interface{}{strconv.Itoa(status): success}
+		for _, code := range op.Errors {
+			responses[strconv.Itoa(code)] = map[string]interface{}{
+				"description": http.StatusText(code),
+				"content":     jsonContent(errorSchema),
+			}
+		}
+		operation["responses"] = responses
+		if op.Auth {
+			operation["security"] = []map[string][]string{{"bearer": {}}}
+		}
+		item[strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    map[string]interface{}{"title": title, "version": version},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.Components(),
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "An access token, a guest token or an API key.",
+				},
+			},
+		},
+	}
+}
+
+func jsonContent(schema Schema) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
@@ -0,0 +1,165 @@
+// Package apidoc builds OpenAPI and AsyncAPI documents whose schemas are
+// derived from the Go types that are encoded on the wire, so that the
+// documents change together with the code.
+package apidoc
+
+import (
+	"encoding"
+	"encoding/json"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Schema is a JSON Schema object.
+type Schema map[string]interface{}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// Schemas turns Go types into JSON Schemas. Named struct types become
+// shared components that other schemas refer to.
+type Schemas struct {
+	refPrefix  string
+	components map[string]Schema
+	names      map[reflect.Type]string
+	overrides  map[reflect.Type]interface{}
+}
+
+// NewSchemas returns a registry whose references start with refPrefix,
+// e.g. "#/components/schemas/".
+func NewSchemas(refPrefix string) *Schemas {
+	return &Schemas{
+		refPrefix:  refPrefix,
+		components: make(map[string]Schema),
+		names:      make(map[reflect.Type]string),
+		overrides:  make(map[reflect.Type]interface{}),
+	}
+}
+
+// Override documents values of the type of target as the schema of as.
+// It is needed for interfaces and for types with their own MarshalJSON.
+// Pass a nil pointer to an interface as target, e.g. (*models.IUser)(nil).
+func (s *Schemas) Override(target interface{}, as interface{}) {
+	t := reflect.TypeOf(target)
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Interface {
+		t = t.Elem()
+	}
+	s.overrides[t] = as
+}
+
+// Components returns every named schema referred to so far.
+func (s *Schemas) Components() map[string]Schema {
+	return s.components
+}
+
+// OneOf documents a body that has the type of one of its values.
+type OneOf []interface{}
+
+// Of returns the schema of v's type.
+func (s *Schemas) Of(v interface{}) Schema {
+	if one, ok := v.(OneOf); ok {
+		alternatives := make([]Schema, 0, len(one))
+		for _, alternative := range one {
+			alternatives = append(alternatives, s.Of(alternative))
+		}
+		return Schema{"oneOf": alternatives}
+	}
+	return s.schema(reflect.TypeOf(v))
+}
+
+func (s *Schemas) schema(t reflect.Type) Schema {
+	if as, ok := s.overrides[t]; ok {
+		return s.Of(as)
+	}
+	if t.Kind() == reflect.Ptr {
+		return s.schema(t.Elem())
+	}
+
+	switch {
+	case t == timeType:
+		return Schema{"type": "string", "format": "date-time"}
+	case t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType):
+		return Schema{}
+	case t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType):
+		return Schema{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return Schema{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		return s.ref(t)
+	}
+	return Schema{}
+}
+
+// ref registers the named struct type t and refers to it.
+func (s *Schemas) ref(t reflect.Type) Schema {
+	name, ok := s.names[t]
+	if !ok {
+		name = t.Name()
+		if _, taken := s.components[name]; taken {
+			name = path.Base(t.PkgPath()) + "." + name
+		}
+		s.names[t] = name
+		// Reserve the name first so that recursive types terminate.
+		s.components[name] = Schema{}
+		s.components[name] = s.object(t)
+	}
+	return Schema{"$ref": s.refPrefix + name}
+}
+
+// object lists the fields encoding/json writes for t, including those of
+// embedded structs.
+func (s *Schemas) object(t reflect.Type) Schema {
+	properties := make(map[string]interface{})
+	s.addFields(t, properties)
+	return Schema{"type": "object", "properties": properties}
+}
+
+func (s *Schemas) addFields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				s.addFields(embedded, properties)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = s.schema(field.Type)
+	}
+}
@@ -0,0 +1,134 @@
+// Package apierror writes the JSON error responses of the HTTP API and
+// tags every request with an ID that the responses echo.
+package apierror
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the request ID. A valid ID sent by the client or
+// a proxy is kept, otherwise the server assigns one.
+const RequestIDHeader = "X-Request-ID"
+
+// Error codes. Each HTTP status the API uses has one.
+const (
+	CodeBadRequest       = "bad_request"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodeValidation       = "validation_failed"
+	CodeTooManyRequests  = "too_many_requests"
+	CodeInternal         = "internal"
+	CodeUnavailable      = "unavailable"
+)
+
+type contextKey string
+
+const requestIDKey contextKey = "request_id"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// Error is the body of every error response, wrapped in ErrorResponse.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Fields lists what is wrong with each invalid field of the request.
+	Fields    []FieldError `json:"fields,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ErrorResponse struct {
+	Error *Error `json:"error"`
+}
+
+// Code returns the error code of status.
+func Code(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusUnprocessableEntity:
+		return CodeValidation
+	case http.StatusTooManyRequests:
+		return CodeTooManyRequests
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	}
+	return CodeInternal
+}
+
+// Write responds with status and message. An empty message is replaced by
+// the status text.
+func Write(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	write(w, status, &Error{Code: Code(status), Message: message, RequestID: RequestID(r.Context())})
+}
+
+// Status responds with status and its status text.
+func Status(w http.ResponseWriter, r *http.Request, status int) {
+	Write(w, r, status, "")
+}
+
+// WriteFields responds with 422 and what is wrong with each field.
+func WriteFields(w http.ResponseWriter, r *http.Request, fields []FieldError) {
+	write(w, http.StatusUnprocessableEntity, &Error{
+		Code:      CodeValidation,
+		Message:   "the request has invalid fields",
+		Fields:    fields,
+		RequestID: RequestID(r.Context()),
+	})
+}
+
+// WriteField is WriteFields for a single invalid field.
+func WriteField(w http.ResponseWriter, r *http.Request, field string, message string) {
+	WriteFields(w, r, []FieldError{{Field: field, Message: message}})
+}
+
+func write(w http.ResponseWriter, status int, e *Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&ErrorResponse{Error: e})
+}
+
+// WithRequestID assigns every request an ID, available through RequestID,
+// and returns it in the RequestIDHeader response header.
+func WithRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+// RequestID returns the ID WithRequestID gave the request of ctx.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
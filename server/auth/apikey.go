@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"strings"
+
+	"github.com/issy20/go-websocket/models"
+)
+
+// APIKeyPrefix tells API keys apart from JWTs wherever a token is accepted.
+const APIKeyPrefix = "wsk_"
+
+var apiKeys models.APIKeyRepository
+
+// UseAPIKeyRepository sets where AuthMiddleware looks up API keys.
+func UseAPIKeyRepository(repository models.APIKeyRepository) {
+	apiKeys = repository
+}
+
+// BotUser is a service account authenticated with one of its API keys.
+type BotUser struct {
+	Id     string `json:"id"`
+	Name   string `json:"name"`
+	KeyID  string `json:"-"`
+	Scopes []string
+}
+
+func (user *BotUser) GetId() string {
+	return user.Id
+}
+
+func (user *BotUser) GetName() string {
+	return user.Name
+}
+
+func (user *BotUser) IsBot() bool {
+	return true
+}
+
+func (user *BotUser) HasScope(scope string) bool {
+	for _, s := range user.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// NewAPIKey returns a new API key and the hash to store for it.
+func NewAPIKey() (key string, hash string, err error) {
+	secret, _, err := NewRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+	key = APIKeyPrefix + secret
+	return key, HashRefreshToken(key), nil
+}
+
+// ValidateAPIKey returns the service account the key belongs to.
+func ValidateAPIKey(key string) (models.IUser, error) {
+	apiKey, err := apiKeys.FindAPIKeyByHash(HashRefreshToken(key))
+	if err != nil {
+		return nil, err
+	}
+	return &BotUser{Id: apiKey.UserID, Name: apiKey.UserName, KeyID: apiKey.ID, Scopes: apiKey.Scopes}, nil
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/issy20/go-websocket/config"
+)
+
+// SubprotocolTokenPrefix marks the Sec-WebSocket-Protocol entry carrying a
+// token, e.g. "bearer.<token>". Browsers require the server to pick one of
+// the offered protocols, so clients offer it next to WebSocketSubprotocol.
+const SubprotocolTokenPrefix = "bearer."
+const WebSocketSubprotocol = "chat"
+
+var authConfig *config.AuthConfig
+
+// UseAuthConfig sets where AuthMiddleware looks for tokens.
+func UseAuthConfig(c *config.AuthConfig) {
+	authConfig = c
+}
+
+// tokenFromRequest looks for a token in the Authorization header, the session
+// cookie, the WebSocket subprotocols and, if allowed, the query string.
+func tokenFromRequest(r *http.Request) (string, bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")); token != header {
+			return token, true
+		}
+	}
+	if cookie, err := r.Cookie(authConfig.CookieName); err == nil && cookie.Value != "" {
+		return cookie.Value, true
+	}
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			protocol = strings.TrimSpace(protocol)
+			if strings.HasPrefix(protocol, SubprotocolTokenPrefix) {
+				return strings.TrimPrefix(protocol, SubprotocolTokenPrefix), true
+			}
+		}
+	}
+	if authConfig.AllowQueryToken {
+		if token, ok := r.URL.Query()["bearer"]; ok && len(token) == 1 {
+			return token[0], true
+		}
+	}
+	return "", false
+}
+
+// SetTokenCookie stores an access token in the HttpOnly session cookie.
+func SetTokenCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     authConfig.CookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(AccessTokenTTL() / time.Second),
+		HttpOnly: true,
+		Secure:   authConfig.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func ClearTokenCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     authConfig.CookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   authConfig.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SigningMethodEdDSA signs tokens with Ed25519, which jwt-go v3 lacks.
+type SigningMethodEdDSA struct{}
+
+var EdDSA = &SigningMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(EdDSA.Alg(), func() jwt.SigningMethod {
+		return EdDSA
+	})
+}
+
+func (m *SigningMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (m *SigningMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return errors.New("ed25519: verification error")
+	}
+	return nil
+}
+
+func (m *SigningMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
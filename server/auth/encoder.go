@@ -4,13 +4,20 @@ import (
 	"crypto/rand"
 	"crypto/subtle"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
 	"strings"
 
 	"golang.org/x/crypto/argon2"
 )
 
+var (
+	ErrInvalidHash         = errors.New("invalid password hash")
+	ErrIncompatibleVersion = errors.New("incompatible argon2 version")
+)
+
+const saltLen = 16
+
 type PasswordConfig struct {
 	time    uint32
 	memory  uint32
@@ -18,17 +25,26 @@ type PasswordConfig struct {
 	keyLen  uint32
 }
 
+// hashParams are the parameters new hashes are generated with. They are
+// replaced by UsePasswordConfig.
+var hashParams = &PasswordConfig{
+	time:    1,
+	memory:  64 * 1024,
+	threads: 4,
+	keyLen:  32,
+}
+
 func GeneratePassword(password string) (string, error) {
-	c := &PasswordConfig{
-		time:    1,
-		memory:  64 * 1024,
-		threads: 4,
-		keyLen:  32,
-	}
-	salt := make([]byte, 16)
+	c := hashParams
+	salt := make([]byte, saltLen)
 	if _, err := rand.Read(salt); err != nil {
 		return "", err
 	}
+	release, err := acquireHashSlot()
+	if err != nil {
+		return "", err
+	}
+	defer release()
 	hash := argon2.IDKey([]byte(password), salt, c.time, c.memory, c.threads, c.keyLen)
 
 	b64Salt := base64.RawStdEncoding.EncodeToString(salt)
@@ -39,49 +55,81 @@ func GeneratePassword(password string) (string, error) {
 	return full, nil
 }
 
-// func ComparePassword(password, hash string) (bool, error) {
-// 	parts := strings.Split(hash, "$")
-// 	c := &PasswordConfig{}
-// 	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &c.memory, &c.time, &c.threads)
-// 	if err != nil {
-// 		return false, nil
-// 	}
-// 	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
-// 	if err != nil {
-// 		return false, err
-// 	}
-// 	decodedHash, err := base64.RawStdEncoding.DecodeString(parts[5])
-// 	if err != nil {
-// 		return false, err
-// 	}
-// 	c.keyLen = uint32(len(decodedHash))
-// 	comparisonHash := argon2.IDKey([]byte(password), salt, c.time, c.memory, c.threads, c.keyLen)
-
-// 	return (subtle.ConstantTimeCompare(decodedHash, comparisonHash) == 1), nil
-// }
-func ComparePassword(password, hash string) (bool, error) {
-
+// decodeHash splits an encoded hash into its parameters, salt and key.
+func decodeHash(hash string) (*PasswordConfig, []byte, []byte, error) {
 	parts := strings.Split(hash, "$")
-	log.Print(parts)
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return nil, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, nil, nil, ErrInvalidHash
+	}
+	if version != argon2.Version {
+		return nil, nil, nil, ErrIncompatibleVersion
+	}
 
 	c := &PasswordConfig{}
-	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &c.memory, &c.time, &c.threads)
-	if err != nil {
-		return false, err
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &c.memory, &c.time, &c.threads); err != nil {
+		return nil, nil, nil, ErrInvalidHash
+	}
+	if c.memory == 0 || c.time == 0 || c.threads == 0 {
+		return nil, nil, nil, ErrInvalidHash
 	}
 
 	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) == 0 {
+		return nil, nil, nil, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrInvalidHash
+	}
+	c.keyLen = uint32(len(key))
+	return c, salt, key, nil
+}
+
+// ComparePassword checks password against an encoded hash. Malformed hashes
+// return ErrInvalidHash.
+func ComparePassword(password, hash string) (bool, error) {
+	c, salt, decodedHash, err := decodeHash(hash)
 	if err != nil {
 		return false, err
 	}
 
-	decodedHash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	release, err := acquireHashSlot()
 	if err != nil {
 		return false, err
 	}
-	c.keyLen = uint32(len(decodedHash))
-
+	defer release()
 	comparisonHash := argon2.IDKey([]byte(password), salt, c.time, c.memory, c.threads, c.keyLen)
 
 	return (subtle.ConstantTimeCompare(decodedHash, comparisonHash) == 1), nil
 }
+
+// CompareDummyPassword takes as long as ComparePassword against a hash with
+// the current parameters and never matches. Logins of unknown usernames use
+// it, so that response times do not tell which usernames exist.
+func CompareDummyPassword(password string) error {
+	c := hashParams
+	salt := base64.RawStdEncoding.EncodeToString(make([]byte, saltLen))
+	key := base64.RawStdEncoding.EncodeToString(make([]byte, c.keyLen))
+	hash := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, c.memory, c.time, c.threads, salt, key)
+	_, err := ComparePassword(password, hash)
+	return err
+}
+
+// NeedsRehash reports whether hash was generated with weaker parameters than
+// the current ones and should be replaced on the next successful login.
+func NeedsRehash(hash string) bool {
+	c, salt, _, err := decodeHash(hash)
+	if err != nil {
+		return true
+	}
+	return c.time < hashParams.time ||
+		c.memory < hashParams.memory ||
+		c.threads < hashParams.threads ||
+		c.keyLen < hashParams.keyLen ||
+		len(salt) < saltLen
+}
@@ -0,0 +1,27 @@
+package auth
+
+import "testing"
+
+func TestComparePassword(t *testing.T) {
+	hash, err := GeneratePassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := ComparePassword("correct horse", hash); !ok || err != nil {
+		t.Fatalf("right password: got %v, %v", ok, err)
+	}
+	if ok, err := ComparePassword("wrong horse", hash); ok || err != nil {
+		t.Fatalf("wrong password: got %v, %v", ok, err)
+	}
+	if _, err := ComparePassword("correct horse", "$argon2id$garbage"); err != ErrInvalidHash {
+		t.Fatalf("malformed hash: got %v, want %v", err, ErrInvalidHash)
+	}
+}
+
+// TestCompareDummyPassword checks that the dummy hash is well-formed, so
+// that unknown usernames really pay for a comparison.
+func TestCompareDummyPassword(t *testing.T) {
+	if err := CompareDummyPassword("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrHashBusy is returned when no password hashing slot frees up in time.
+var ErrHashBusy = errors.New("password hashing busy")
+
+var (
+	hashSlots chan struct{}
+	hashWait  time.Duration
+)
+
+// UseHashPool bounds the password hashes computed at once. Each argon2
+// computation takes 64 MiB, so unbounded logins could exhaust memory.
+func UseHashPool(size int, wait time.Duration) {
+	hashSlots = make(chan struct{}, size)
+	hashWait = wait
+}
+
+func acquireHashSlot() (release func(), err error) {
+	if hashSlots == nil {
+		return func() {}, nil
+	}
+	timer := time.NewTimer(hashWait)
+	defer timer.Stop()
+	select {
+	case hashSlots <- struct{}{}:
+		return func() { <-hashSlots }, nil
+	case <-timer.C:
+		return nil, ErrHashBusy
+	}
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"sort"
+)
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists the public keys of the set. HMAC secrets are never published,
+// so services can only verify tokens signed with asymmetric keys.
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		jwk := JWK{Kid: key.ID, Alg: key.Method.Alg(), Use: "sig"}
+		switch k := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = encodeJWKInt(k.N, 0)
+			jwk.E = encodeJWKInt(big.NewInt(int64(k.E)), 0)
+		case *ecdsa.PublicKey:
+			size := (k.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = k.Curve.Params().Name
+			jwk.X = encodeJWKInt(k.X, size)
+			jwk.Y = encodeJWKInt(k.Y, size)
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(k)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+func encodeJWKInt(n *big.Int, size int) string {
+	b := n.Bytes()
+	if len(b) < size {
+		b = append(make([]byte, size-len(b)), b...)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// HandleJWKS serves the public keys at /.well-known/jwks.json.
+func HandleJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(keys.JWKS())
+}
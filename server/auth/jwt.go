@@ -1,51 +1,196 @@
 package auth
 
 import (
-	"fmt"
+	"errors"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/issy20/go-websocket/config"
 	"github.com/issy20/go-websocket/models"
 )
 
-const hmacSecret = "WjdwZUh2dWJGdFB1UWRybg=="
-const defaultExpireTime = 604800 // 1 week
+var (
+	ErrTokenNoSubject   = errors.New("token has no subject")
+	ErrTokenExpired     = errors.New("token is expired")
+	ErrTokenNotValidYet = errors.New("token is not valid yet")
+	ErrTokenIssuer      = errors.New("token has an unexpected issuer")
+	ErrTokenAudience    = errors.New("token has an unexpected audience")
+	ErrTokenRevoked     = errors.New("token session has been revoked")
+	ErrTokenPurpose     = errors.New("token has an unexpected purpose")
+)
+
+// mfaPurpose marks the intermediate tokens of a login waiting for its
+// second factor. They are never accepted as access tokens.
+const mfaPurpose = "mfa"
+
+var jwtConfig *config.JWTConfig
+
+// UseJWTConfig sets the issuer, audience, lifetime and clock skew of tokens.
+func UseJWTConfig(c *config.JWTConfig) {
+	jwtConfig = c
+}
 
 type Claims struct {
-	ID   string `json:"id"`
 	Name string `json:"name"`
+	// SessionID names the login session the token was issued for, so that
+	// revoking the session invalidates its tokens.
+	SessionID string `json:"sid,omitempty"`
+	// Guest marks tokens that keep a guest's identity across reconnects.
+	Guest bool `json:"guest,omitempty"`
+	// Purpose is set on tokens that are not access tokens.
+	Purpose string `json:"pur,omitempty"`
 	jwt.StandardClaims
 }
 
 func (c *Claims) GetId() string {
-	return c.ID
+	return c.Subject
 }
 
 func (c *Claims) GetName() string {
 	return c.Name
 }
 
-func CreateJWTToken(user models.IUser) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"Id":        user.GetId(),
-		"Name":      user.GetName(),
-		"ExpiresAt": time.Now().Unix() + defaultExpireTime,
+func (c *Claims) GetSessionId() string {
+	return c.SessionID
+}
+
+func (c *Claims) IsGuest() bool {
+	return c.Guest
+}
+
+// validate checks the registered claims, allowing for the configured clock skew.
+func (c *Claims) validate(now time.Time) error {
+	skew := int64(jwtConfig.ClockSkew / time.Second)
+	unix := now.Unix()
+	if c.Subject == "" {
+		return ErrTokenNoSubject
+	}
+	if c.ExpiresAt == 0 || unix > c.ExpiresAt+skew {
+		return ErrTokenExpired
+	}
+	if unix+skew < c.NotBefore || unix+skew < c.IssuedAt {
+		return ErrTokenNotValidYet
+	}
+	if !c.VerifyIssuer(jwtConfig.Issuer, true) {
+		return ErrTokenIssuer
+	}
+	if !c.VerifyAudience(jwtConfig.Audience, true) {
+		return ErrTokenAudience
+	}
+	return nil
+}
+
+func CreateJWTToken(user models.IUser, sessionID string) (string, error) {
+	now := time.Now()
+	return keys.sign(&Claims{
+		Name:      user.GetName(),
+		SessionID: sessionID,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   user.GetId(),
+			Issuer:    jwtConfig.Issuer,
+			Audience:  jwtConfig.Audience,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(jwtConfig.AccessTokenTTL).Unix(),
+		},
 	})
-	tokenString, err := token.SignedString([]byte(hmacSecret))
-	return tokenString, err
 }
 
-func ValidateToken(tokenString string) (models.IUser, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(hmacSecret), nil
+// CreateGuestToken signs the identity of a guest so that it can reconnect
+// under the same ID and name.
+func CreateGuestToken(user models.IUser) (string, error) {
+	now := time.Now()
+	return keys.sign(&Claims{
+		Name:  user.GetName(),
+		Guest: true,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   user.GetId(),
+			Issuer:    jwtConfig.Issuer,
+			Audience:  jwtConfig.Audience,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(authConfig.GuestTokenTTL).Unix(),
+		},
+	})
+}
+
+// CreateMFAToken signs the result of a successful password check for the
+// second login step.
+func CreateMFAToken(user models.IUser) (string, error) {
+	now := time.Now()
+	return keys.sign(&Claims{
+		Name:    user.GetName(),
+		Purpose: mfaPurpose,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   user.GetId(),
+			Issuer:    jwtConfig.Issuer,
+			Audience:  jwtConfig.Audience,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(authConfig.MFATokenTTL).Unix(),
+		},
 	})
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
-	} else {
+}
+
+// ValidateMFAToken returns the ID of the user who passed the password check.
+func ValidateMFAToken(tokenString string) (string, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return "", err
+	}
+	if claims.Purpose != mfaPurpose {
+		return "", ErrTokenPurpose
+	}
+	return claims.Subject, nil
+}
+
+func parseToken(tokenString string) (*Claims, error) {
+	// The registered claims are checked by Claims.validate, which unlike
+	// jwt-go allows for clock skew.
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(tokenString, &Claims{}, keys.lookup)
+	if err != nil {
 		return nil, err
 	}
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	if err := claims.validate(time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+var users models.UserRepository
 
+// UseUserRepository sets where ValidateToken looks up whether a guest has
+// been upgraded to a registered user.
+func UseUserRepository(repository models.UserRepository) {
+	users = repository
+}
+
+func ValidateToken(tokenString string) (models.IUser, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, ErrTokenPurpose
+	}
+	if claims.SessionID != "" && sessions != nil {
+		revoked, err := sessions.IsSessionRevoked(claims.SessionID)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+	// Upgrading keeps the guest's ID, so its guest tokens would otherwise
+	// keep working next to the new login.
+	if claims.Guest && users != nil && users.FindUserById(claims.Subject) != nil {
+		return nil, ErrTokenRevoked
+	}
+	return claims, nil
 }
@@ -0,0 +1,275 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/issy20/go-websocket/config"
+	"github.com/issy20/go-websocket/models"
+)
+
+const (
+	testIssuer   = "test-issuer"
+	testAudience = "test-audience"
+	testSkew     = 30 * time.Second
+)
+
+type testUser struct {
+	id   string
+	name string
+}
+
+func (u testUser) GetId() string   { return u.id }
+func (u testUser) GetName() string { return u.name }
+
+// registeredUsers is a user repository that only knows which IDs exist.
+type registeredUsers map[string]bool
+
+func (u registeredUsers) AddUser(id string, name string, username string, email string, password string) error {
+	u[id] = true
+	return nil
+}
+func (u registeredUsers) RemoveUser(user models.IUser) { delete(u, user.GetId()) }
+func (u registeredUsers) FindUserById(ID string) models.IUser {
+	if !u[ID] {
+		return nil
+	}
+	return testUser{id: ID}
+}
+func (u registeredUsers) GetAllUsers() []models.IUser                          { return nil }
+func (u registeredUsers) FindDirectMessagePolicy(id string) (string, error)    { return "", nil }
+func (u registeredUsers) SetDirectMessagePolicy(id string, allow string) error { return nil }
+
+// setupKeys signs with an HMAC key and also trusts an RSA key, like a
+// deployment in the middle of a key rotation.
+func setupKeys(t *testing.T) (hmacKey *Key, rsaKey *Key) {
+	t.Helper()
+	UseJWTConfig(&config.JWTConfig{
+		Issuer:         testIssuer,
+		Audience:       testAudience,
+		ClockSkew:      testSkew,
+		AccessTokenTTL: 15 * time.Minute,
+	})
+	UseAuthConfig(&config.AuthConfig{MFATokenTTL: 5 * time.Minute, GuestTokenTTL: time.Hour})
+	hmacKey = NewHMACKey("current", []byte("test secret"))
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey = &Key{ID: "previous", Method: jwt.SigningMethodRS256, Private: private, Public: &private.PublicKey}
+	keySet, err := NewKeySet("current", hmacKey, &Key{ID: rsaKey.ID, Method: rsaKey.Method, Public: rsaKey.Public})
+	if err != nil {
+		t.Fatal(err)
+	}
+	UseKeySet(keySet)
+	UseSessionRepository(nil)
+	UseUserRepository(nil)
+	return hmacKey, rsaKey
+}
+
+func validClaims(now time.Time) *Claims {
+	return &Claims{
+		Name: "alice",
+		StandardClaims: jwt.StandardClaims{
+			Subject:   "user-1",
+			Issuer:    testIssuer,
+			Audience:  testAudience,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(time.Minute).Unix(),
+		},
+	}
+}
+
+func signWith(t *testing.T, key *Key, kid string, claims jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(key.Method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key.Private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestValidateToken(t *testing.T) {
+	hmacKey, _ := setupKeys(t)
+	token, err := CreateJWTToken(testUser{id: "user-1", name: "alice"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.GetId() != "user-1" || user.GetName() != "alice" {
+		t.Fatalf("got user %s %s", user.GetId(), user.GetName())
+	}
+
+	// Tokens issued before key rotation have no kid.
+	if _, err := ValidateToken(signWith(t, hmacKey, "", validClaims(time.Now()))); err != nil {
+		t.Fatalf("token without kid: %v", err)
+	}
+}
+
+func TestValidateTokenClaims(t *testing.T) {
+	hmacKey, _ := setupKeys(t)
+	now := time.Now()
+	tests := []struct {
+		name   string
+		modify func(c *Claims)
+		want   error
+	}{
+		{"expired", func(c *Claims) { c.ExpiresAt = now.Add(-time.Hour).Unix() }, ErrTokenExpired},
+		{"no expiry", func(c *Claims) { c.ExpiresAt = 0 }, ErrTokenExpired},
+		{"not valid yet", func(c *Claims) { c.NotBefore = now.Add(time.Hour).Unix() }, ErrTokenNotValidYet},
+		{"issued in the future", func(c *Claims) { c.IssuedAt = now.Add(time.Hour).Unix() }, ErrTokenNotValidYet},
+		{"wrong audience", func(c *Claims) { c.Audience = "someone-else" }, ErrTokenAudience},
+		{"no audience", func(c *Claims) { c.Audience = "" }, ErrTokenAudience},
+		{"wrong issuer", func(c *Claims) { c.Issuer = "someone-else" }, ErrTokenIssuer},
+		{"no subject", func(c *Claims) { c.Subject = "" }, ErrTokenNoSubject},
+		{"mfa purpose", func(c *Claims) { c.Purpose = mfaPurpose }, ErrTokenPurpose},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims(now)
+			tt.modify(claims)
+			_, err := ValidateToken(signWith(t, hmacKey, hmacKey.ID, claims))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestClockSkew checks the boundaries of the leeway on exp, nbf and iat.
+func TestClockSkew(t *testing.T) {
+	setupKeys(t)
+	now := time.Unix(1700000000, 0)
+	skew := int64(testSkew / time.Second)
+	tests := []struct {
+		name   string
+		modify func(c *Claims)
+		want   error
+	}{
+		{"expired within skew", func(c *Claims) { c.ExpiresAt = now.Unix() - skew }, nil},
+		{"expired beyond skew", func(c *Claims) { c.ExpiresAt = now.Unix() - skew - 1 }, ErrTokenExpired},
+		{"nbf within skew", func(c *Claims) { c.NotBefore = now.Unix() + skew }, nil},
+		{"nbf beyond skew", func(c *Claims) { c.NotBefore = now.Unix() + skew + 1 }, ErrTokenNotValidYet},
+		{"iat within skew", func(c *Claims) { c.IssuedAt = now.Unix() + skew }, nil},
+		{"iat beyond skew", func(c *Claims) { c.IssuedAt = now.Unix() + skew + 1 }, ErrTokenNotValidYet},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims(now)
+			tt.modify(claims)
+			if err := claims.validate(now); err != tt.want {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTokenTampered(t *testing.T) {
+	hmacKey, rsaKey := setupKeys(t)
+	token := signWith(t, hmacKey, hmacKey.ID, validClaims(time.Now()))
+	parts := strings.Split(token, ".")
+
+	forged := validClaims(time.Now())
+	forged.Subject = "admin"
+	payload, err := json.Marshal(forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noneHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+
+	tests := map[string]string{
+		"changed payload":   parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2],
+		"changed signature": parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2])),
+		"no signature":      parts[0] + "." + parts[1] + ".",
+		"alg none":          noneHeader + "." + parts[1] + ".",
+		"other secret":      signWith(t, NewHMACKey(hmacKey.ID, []byte("other secret")), hmacKey.ID, validClaims(time.Now())),
+		"unknown kid":       signWith(t, hmacKey, "unknown", validClaims(time.Now())),
+		// An HMAC token claiming the RSA key's kid must not be checked with
+		// the public key as the HMAC secret.
+		"alg confusion": signWith(t, hmacKey, rsaKey.ID, validClaims(time.Now())),
+		"garbage":       "not.a.token",
+	}
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			if user, err := ValidateToken(token); err == nil {
+				t.Fatalf("accepted token for %s", user.GetId())
+			}
+		})
+	}
+}
+
+func TestValidateTokenRotatedKey(t *testing.T) {
+	_, rsaKey := setupKeys(t)
+	// The verify-only key still accepts the tokens it signed.
+	user, err := ValidateToken(signWith(t, rsaKey, rsaKey.ID, validClaims(time.Now())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.GetId() != "user-1" {
+		t.Fatalf("got user %s", user.GetId())
+	}
+}
+
+func TestValidateMFAToken(t *testing.T) {
+	setupKeys(t)
+	user := testUser{id: "user-1", name: "alice"}
+	token, err := CreateMFAToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateToken(token); !errors.Is(err, ErrTokenPurpose) {
+		t.Fatalf("MFA token accepted as access token: %v", err)
+	}
+	id, err := ValidateMFAToken(token)
+	if err != nil || id != user.id {
+		t.Fatalf("got %q, %v", id, err)
+	}
+
+	access, err := CreateJWTToken(user, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateMFAToken(access); !errors.Is(err, ErrTokenPurpose) {
+		t.Fatalf("access token accepted as MFA token: %v", err)
+	}
+}
+
+func TestValidateGuestTokenAfterUpgrade(t *testing.T) {
+	setupKeys(t)
+	users := registeredUsers{}
+	UseUserRepository(users)
+	defer UseUserRepository(nil)
+
+	guest := NewGuest("bob")
+	token, err := CreateGuestToken(guest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !models.IsGuest(user) || user.GetId() != guest.Id {
+		t.Fatalf("got user %s, guest %v", user.GetId(), models.IsGuest(user))
+	}
+
+	users.AddUser(guest.Id, "Bob", "bob", "", "")
+	if _, err := ValidateToken(token); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("guest token of an upgraded guest: got %v, want %v", err, ErrTokenRevoked)
+	}
+}
@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/issy20/go-websocket/config"
+)
+
+const defaultKeyID = "default"
+
+// Key is a token key identified by its kid. Private is nil for keys that are
+// only used to verify tokens, such as keys being rotated out.
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private interface{}
+	Public  interface{}
+}
+
+// KeySet signs tokens with one key and verifies them with any of its keys.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+var keys *KeySet
+
+// UseKeySet sets the keys CreateJWTToken and ValidateToken use.
+func UseKeySet(keySet *KeySet) {
+	keys = keySet
+}
+
+func NewKeySet(signingKeyID string, keyList ...*Key) (*KeySet, error) {
+	ks := &KeySet{keys: make(map[string]*Key)}
+	for _, key := range keyList {
+		if _, ok := ks.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id: %s", key.ID)
+		}
+		ks.keys[key.ID] = key
+	}
+	signing, ok := ks.keys[signingKeyID]
+	if !ok {
+		return nil, fmt.Errorf("signing key not found: %s", signingKeyID)
+	}
+	if signing.Private == nil {
+		return nil, fmt.Errorf("signing key has no private key: %s", signingKeyID)
+	}
+	ks.signing = signing
+	return ks, nil
+}
+
+// LoadKeySet builds the key set described by c.
+func LoadKeySet(c *config.JWTConfig) (*KeySet, error) {
+	if c.KeysDir == "" {
+		return NewKeySet(defaultKeyID, NewHMACKey(defaultKeyID, []byte(c.HMACSecret)))
+	}
+
+	entries, err := os.ReadDir(c.KeysDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+	var keyList []*Key
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(entry.Name())
+		kid := strings.TrimSuffix(entry.Name(), ext)
+		data, err := os.ReadFile(filepath.Join(c.KeysDir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key %s: %w", kid, err)
+		}
+
+		switch ext {
+		case ".secret":
+			keyList = append(keyList, NewHMACKey(kid, bytes.TrimSpace(data)))
+		case ".pem":
+			key, err := ParsePEMKey(kid, data)
+			if err != nil {
+				return nil, err
+			}
+			keyList = append(keyList, key)
+		}
+	}
+	return NewKeySet(c.SigningKeyID, keyList...)
+}
+
+func NewHMACKey(kid string, secret []byte) *Key {
+	return &Key{ID: kid, Method: jwt.SigningMethodHS256, Private: secret, Public: secret}
+}
+
+// ParsePEMKey reads a PKCS#1, PKCS#8 or SEC 1 private key, or a PKIX public
+// key, and picks RS256, ES256 or EdDSA from its type.
+func ParsePEMKey(kid string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s: no PEM data", kid)
+	}
+
+	var private, public interface{}
+	switch block.Type {
+	case "PUBLIC KEY":
+		k, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+		public = k
+	case "RSA PRIVATE KEY":
+		k, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+		private = k
+	case "EC PRIVATE KEY":
+		k, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+		private = k
+	case "PRIVATE KEY":
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+		private = k
+	default:
+		return nil, fmt.Errorf("key %s: unsupported PEM type %s", kid, block.Type)
+	}
+	if private != nil {
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("key %s: unsupported private key", kid)
+		}
+		public = signer.Public()
+	}
+
+	key := &Key{ID: kid, Private: private}
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+		key.Public = k
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("key %s: ES256 requires a P-256 key", kid)
+		}
+		key.Method = jwt.SigningMethodES256
+		key.Public = k
+	case ed25519.PublicKey:
+		key.Method = EdDSA
+		key.Public = k
+	default:
+		return nil, fmt.Errorf("key %s: unsupported key type %T", kid, public)
+	}
+	return key, nil
+}
+
+// lookup returns the key a token was signed with. Tokens without a kid were
+// issued before key rotation and are checked against the signing key.
+func (ks *KeySet) lookup(token *jwt.Token) (interface{}, error) {
+	key := ks.signing
+	if kid, ok := token.Header["kid"].(string); ok {
+		if key, ok = ks.keys[kid]; !ok {
+			return nil, fmt.Errorf("unknown key id: %s", kid)
+		}
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.Public, nil
+}
+
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.Method, claims)
+	token.Header["kid"] = ks.signing.ID
+	return token.SignedString(ks.signing.Private)
+}
@@ -2,10 +2,14 @@ package auth
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/google/uuid"
+	"github.com/issy20/go-websocket/apierror"
+	"github.com/issy20/go-websocket/models"
 )
 
 type contextKey string
@@ -25,26 +29,61 @@ func (user *AnonUser) GetName() string {
 	return user.Name
 }
 
+func (user *AnonUser) IsGuest() bool {
+	return true
+}
+
+// NewGuest creates a guest with a fresh ID. The name gets a suffix from the
+// ID so that guests cannot pass for each other or for registered users.
+func NewGuest(name string) *AnonUser {
+	id := uuid.New().String()
+	name = strings.TrimSpace(strings.ReplaceAll(name, "#", ""))
+	return &AnonUser{Id: id, Name: fmt.Sprintf("%s#%s", name, id[:4])}
+}
+
 func AuthMiddleware(f http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token, tok := r.URL.Query()["bearer"]
+		token, tok := tokenFromRequest(r)
 		name, nok := r.URL.Query()["name"]
-		if tok && len(token) == 1 {
-			user, err := ValidateToken(token[0])
+		if tok {
+			var user models.IUser
+			var err error
+			if IsAPIKey(token) {
+				user, err = ValidateAPIKey(token)
+			} else {
+				user, err = ValidateToken(token)
+			}
 			if err != nil {
 				log.Print("err", err)
-				http.Error(w, "forbidden", http.StatusForbidden)
+				apierror.Write(w, r, http.StatusUnauthorized, "invalid or expired credentials")
+			} else if models.IsGuest(user) && !authConfig.GuestsEnabled {
+				apierror.Write(w, r, http.StatusForbidden, "guests are disabled")
 			} else {
 				ctx := context.WithValue(r.Context(), UserContextKey, user)
 				f(w, r.WithContext(ctx))
 			}
 		} else if nok && len(name) == 1 {
-			user := AnonUser{Id: uuid.New().String(), Name: name[0]}
-			ctx := context.WithValue(r.Context(), UserContextKey, &user)
+			if !authConfig.GuestsEnabled {
+				apierror.Write(w, r, http.StatusForbidden, "guests are disabled")
+				return
+			}
+			ctx := context.WithValue(r.Context(), UserContextKey, NewGuest(name[0]))
 			f(w, r.WithContext(ctx))
 		} else {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Please login or provide name"))
+			apierror.Write(w, r, http.StatusUnauthorized, "please log in or provide a name")
+		}
+	})
+}
+
+// TokenMiddleware is AuthMiddleware without guests that give just a name.
+// Those get a new ID on every request, so nothing they write could be
+// traced back to them.
+func TokenMiddleware(f http.HandlerFunc) http.HandlerFunc {
+	return AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(UserContextKey).(*AnonUser); ok {
+			apierror.Write(w, r, http.StatusUnauthorized, "a token or API key is required")
+			return
 		}
+		f(w, r)
 	})
 }
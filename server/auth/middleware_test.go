@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/issy20/go-websocket/config"
+	"github.com/issy20/go-websocket/models"
+)
+
+func TestTokenMiddleware(t *testing.T) {
+	setupKeys(t)
+	UseAuthConfig(&config.AuthConfig{GuestsEnabled: true, GuestTokenTTL: time.Hour, CookieName: "token"})
+	var got models.IUser
+	handler := TokenMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Context().Value(UserContextKey).(models.IUser)
+	})
+
+	res := httptest.NewRecorder()
+	handler(res, httptest.NewRequest(http.MethodPost, "/api/rooms/x/messages?name=mallory", nil))
+	if res.Code != http.StatusUnauthorized || got != nil {
+		t.Fatalf("name-only guest: got %d", res.Code)
+	}
+
+	guest := NewGuest("bob")
+	token, err := CreateGuestToken(guest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/rooms/x/messages", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	res = httptest.NewRecorder()
+	handler(res, req)
+	if res.Code != http.StatusOK || got == nil || got.GetId() != guest.Id {
+		t.Fatalf("guest token: got %d", res.Code)
+	}
+}
@@ -0,0 +1,386 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/issy20/go-websocket/config"
+)
+
+const oidcStateTTL = 10 * time.Minute
+
+var ErrOIDCState = errors.New("invalid oidc state")
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider runs the authorization code flow with PKCE against an
+// OpenID Connect identity provider.
+type OIDCProvider struct {
+	config    *config.OIDCConfig
+	client    *http.Client
+	discovery oidcDiscovery
+
+	mu   sync.RWMutex
+	keys map[string]interface{}
+}
+
+// OIDCIdentity is what the identity provider asserts about a user.
+type OIDCIdentity struct {
+	Issuer            string
+	Subject           string
+	Name              string
+	PreferredUsername string
+	Email             string
+}
+
+// Username is the local username provisioned for the identity. Subjects are
+// only unique per issuer, so it covers both, hashed to fit the column.
+func (i *OIDCIdentity) Username() string {
+	sum := sha256.Sum256([]byte(i.Issuer + "\x00" + i.Subject))
+	return "oidc:" + hex.EncodeToString(sum[:])
+}
+
+// OIDCState travels in a signed cookie from the login redirect to the callback.
+type OIDCState struct {
+	State     string `json:"state"`
+	Nonce     string `json:"nonce"`
+	Verifier  string `json:"verifier"`
+	Cookie    bool   `json:"cookie"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// NewOIDCProvider fetches the provider's discovery document.
+func NewOIDCProvider(ctx context.Context, c *config.OIDCConfig) (*OIDCProvider, error) {
+	p := &OIDCProvider{
+		config: c,
+		client: &http.Client{Timeout: 10 * time.Second},
+		keys:   make(map[string]interface{}),
+	}
+	if err := p.getJSON(ctx, c.Issuer+"/.well-known/openid-configuration", &p.discovery); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if p.discovery.Issuer != c.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %s does not match %s", p.discovery.Issuer, c.Issuer)
+	}
+	return p, nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewOIDCState creates the state, nonce and PKCE verifier of one login.
+func NewOIDCState(cookie bool) (*OIDCState, error) {
+	s := &OIDCState{Cookie: cookie, ExpiresAt: time.Now().Add(oidcStateTTL).Unix()}
+	var err error
+	if s.State, err = randomString(); err != nil {
+		return nil, err
+	}
+	if s.Nonce, err = randomString(); err != nil {
+		return nil, err
+	}
+	if s.Verifier, err = randomString(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// AuthCodeURL is where the user is sent to log in.
+func (p *OIDCProvider) AuthCodeURL(s *OIDCState) string {
+	challenge := sha256.Sum256([]byte(s.Verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {s.State},
+		"nonce":                 {s.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.discovery.AuthorizationEndpoint + sep + q.Encode()
+}
+
+func (p *OIDCProvider) stateMAC(payload string) string {
+	mac := hmac.New(sha256.New, []byte(p.config.StateSecret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// EncodeState signs s for the state cookie.
+func (p *OIDCProvider) EncodeState(s *OIDCState) (string, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + p.stateMAC(payload), nil
+}
+
+// DecodeState checks the state cookie and that it belongs to the callback's state.
+func (p *OIDCProvider) DecodeState(value string, state string) (*OIDCState, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(p.stateMAC(parts[0]))) {
+		return nil, ErrOIDCState
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrOIDCState
+	}
+	var s OIDCState
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, ErrOIDCState
+	}
+	if time.Now().Unix() > s.ExpiresAt || !hmac.Equal([]byte(s.State), []byte(state)) {
+		return nil, ErrOIDCState
+	}
+	return &s, nil
+}
+
+// Exchange redeems the authorization code and returns the raw ID token.
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	if p.config.ClientSecret != "" {
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc token exchange: %s", res.Status)
+	}
+
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.IDToken == "" {
+		return "", errors.New("oidc token exchange: no id_token")
+	}
+	return body.IDToken, nil
+}
+
+// audience accepts both forms of the aud claim.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a audience) contains(v string) bool {
+	for _, s := range a {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+type idTokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	Email             string   `json:"email"`
+}
+
+// Valid is checked by VerifyIDToken instead.
+func (c *idTokenClaims) Valid() error {
+	return nil
+}
+
+// VerifyIDToken checks the ID token's signature against the provider's
+// keys and its issuer, audience, expiry and nonce.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, raw string, nonce string) (*OIDCIdentity, error) {
+	parser := &jwt.Parser{
+		ValidMethods:         []string{"RS256", "ES256", EdDSA.Alg()},
+		SkipClaimsValidation: true,
+	}
+	var claims idTokenClaims
+	_, err := parser.ParseWithClaims(raw, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	skew := int64(jwtConfig.ClockSkew / time.Second)
+	switch {
+	case claims.Issuer != p.discovery.Issuer:
+		return nil, ErrTokenIssuer
+	case !claims.Audience.contains(p.config.ClientID):
+		return nil, ErrTokenAudience
+	case time.Now().Unix() > claims.ExpiresAt+skew:
+		return nil, ErrTokenExpired
+	case claims.Subject == "":
+		return nil, ErrTokenNoSubject
+	case !hmac.Equal([]byte(claims.Nonce), []byte(nonce)):
+		return nil, errors.New("id token nonce mismatch")
+	}
+	return &OIDCIdentity{
+		Issuer:            claims.Issuer,
+		Subject:           claims.Subject,
+		Name:              claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+		Email:             claims.Email,
+	}, nil
+}
+
+// key returns the provider key kid, refetching the key set once when the
+// provider has rotated to a key we have not seen.
+func (p *OIDCProvider) key(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.RLock()
+	key, ok := p.keys[kid]
+	p.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	var set JWKS
+	if err := p.getJSON(ctx, p.discovery.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("oidc jwks: %w", err)
+	}
+	keys := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if k, err := jwk.PublicKey(); err == nil {
+			keys[jwk.Kid] = k
+		}
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id: %s", kid)
+}
+
+// PublicKey converts the JWK into an RSA, ECDSA P-256 or Ed25519 public key.
+func (jwk JWK) PublicKey() (interface{}, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		// ed25519.Verify panics on keys of any other length.
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key length: %d", len(x))
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type: %s", jwk.Kty)
+}
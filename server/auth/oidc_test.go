@@ -0,0 +1,329 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/issy20/go-websocket/config"
+)
+
+const testClientID = "test-client"
+
+// stubIdP is an identity provider with discovery, JWKS and token endpoints.
+// Codes are handed out by authorize, which stands in for the login page.
+type stubIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *Key
+
+	mu    sync.Mutex
+	codes map[string]stubGrant
+	// claims changes the ID token of the next exchange.
+	claims func(c *idTokenClaims)
+}
+
+type stubGrant struct {
+	challenge string
+	nonce     string
+	subject   string
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	t.Helper()
+	UseJWTConfig(&config.JWTConfig{ClockSkew: testSkew})
+	idp := &stubIdP{t: t, codes: make(map[string]stubGrant)}
+	idp.rotate("idp-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&oidcDiscovery{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JWKSURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		key := idp.key
+		idp.mu.Unlock()
+		keySet, err := NewKeySet(key.ID, key)
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(keySet.JWKS())
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// rotate makes the provider sign with a new key.
+func (idp *stubIdP) rotate(kid string) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	idp.mu.Lock()
+	idp.key = &Key{ID: kid, Method: jwt.SigningMethodRS256, Private: private, Public: &private.PublicKey}
+	idp.mu.Unlock()
+}
+
+func (idp *stubIdP) config() *config.OIDCConfig {
+	return &config.OIDCConfig{
+		Issuer:      idp.server.URL,
+		ClientID:    testClientID,
+		RedirectURL: "https://chat.example/api/oidc/callback",
+		Scopes:      []string{"openid", "profile"},
+		StateSecret: "state secret",
+	}
+}
+
+// authorize logs subject in at the authorization URL and returns the code
+// and state the provider redirects back with.
+func (idp *stubIdP) authorize(authURL string, subject string) (code string, state string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("client_id") != testClientID || q.Get("code_challenge_method") != "S256" || q.Get("response_type") != "code" {
+		idp.t.Fatalf("unexpected authorization request: %s", u.RawQuery)
+	}
+	code = randomCode(idp.t)
+	idp.mu.Lock()
+	idp.codes[code] = stubGrant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), subject: subject}
+	idp.mu.Unlock()
+	return code, q.Get("state")
+}
+
+func (idp *stubIdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	idp.mu.Lock()
+	grant, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	key, modify := idp.key, idp.claims
+	idp.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge || r.PostForm.Get("client_id") != testClientID {
+		http.Error(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	claims := &idTokenClaims{
+		Issuer:    idp.server.URL,
+		Subject:   grant.subject,
+		Audience:  audience{testClientID},
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Minute).Unix(),
+		Nonce:     grant.nonce,
+		Name:      "Alice",
+		Email:     "alice@example.com",
+	}
+	if modify != nil {
+		modify(claims)
+	}
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.Private)
+	if err != nil {
+		idp.t.Error(err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+}
+
+func randomCode(t *testing.T) string {
+	code, err := randomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// login runs the flow the way the login and callback handlers do.
+func login(t *testing.T, idp *stubIdP, p *OIDCProvider, subject string) (*OIDCIdentity, error) {
+	t.Helper()
+	state, err := NewOIDCState(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie, err := p.EncodeState(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, returnedState := idp.authorize(p.AuthCodeURL(state), subject)
+	decoded, err := p.DecodeState(cookie, returnedState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := p.Exchange(context.Background(), code, decoded.Verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.VerifyIDToken(context.Background(), raw, decoded.Nonce)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := newStubIdP(t)
+	p, err := NewOIDCProvider(context.Background(), idp.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := login(t, idp, p, "subject-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Issuer != idp.server.URL || identity.Subject != "subject-1" || identity.Name != "Alice" || identity.Email != "alice@example.com" {
+		t.Fatalf("got identity %+v", identity)
+	}
+
+	// A rotated provider key is fetched on first sight.
+	idp.rotate("idp-2")
+	if _, err := login(t, idp, p, "subject-1"); err != nil {
+		t.Fatalf("after key rotation: %v", err)
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&oidcDiscovery{Issuer: "https://evil.example"})
+	}))
+	defer server.Close()
+	if _, err := NewOIDCProvider(context.Background(), &config.OIDCConfig{Issuer: server.URL, ClientID: testClientID}); err == nil {
+		t.Fatal("accepted a discovery document of another issuer")
+	}
+}
+
+func TestOIDCExchangeWrongVerifier(t *testing.T) {
+	idp := newStubIdP(t)
+	p, err := NewOIDCProvider(context.Background(), idp.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := NewOIDCState(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := idp.authorize(p.AuthCodeURL(state), "subject-1")
+	if _, err := p.Exchange(context.Background(), code, "another verifier"); err == nil {
+		t.Fatal("code redeemed without its PKCE verifier")
+	}
+}
+
+func TestOIDCDecodeState(t *testing.T) {
+	idp := newStubIdP(t)
+	p, err := NewOIDCProvider(context.Background(), idp.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := NewOIDCState(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie, err := p.EncodeState(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := p.DecodeState(cookie, state.State); err != nil || !decoded.Cookie || decoded.Verifier != state.Verifier {
+		t.Fatalf("got %+v, %v", decoded, err)
+	}
+
+	other, err := NewOIDCState(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := *state
+	expired.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	expiredCookie, err := p.EncodeState(&expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct{ cookie, state string }{
+		"other state":      {cookie, other.State},
+		"tampered payload": {"e30." + cookie[len(cookie)-43:], state.State},
+		"expired":          {expiredCookie, state.State},
+		"garbage":          {"garbage", state.State},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := p.DecodeState(tt.cookie, tt.state); !errors.Is(err, ErrOIDCState) {
+				t.Fatalf("got %v, want %v", err, ErrOIDCState)
+			}
+		})
+	}
+}
+
+func TestOIDCVerifyIDToken(t *testing.T) {
+	idp := newStubIdP(t)
+	p, err := NewOIDCProvider(context.Background(), idp.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		modify func(c *idTokenClaims)
+		want   error
+	}{
+		{"wrong issuer", func(c *idTokenClaims) { c.Issuer = "https://evil.example" }, ErrTokenIssuer},
+		{"wrong audience", func(c *idTokenClaims) { c.Audience = audience{"another-client"} }, ErrTokenAudience},
+		{"expired", func(c *idTokenClaims) { c.ExpiresAt = time.Now().Add(-time.Hour).Unix() }, ErrTokenExpired},
+		{"no subject", func(c *idTokenClaims) { c.Subject = "" }, ErrTokenNoSubject},
+		{"other nonce", func(c *idTokenClaims) { c.Nonce = "replayed" }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp.mu.Lock()
+			idp.claims = tt.modify
+			idp.mu.Unlock()
+			identity, err := login(t, idp, p, "subject-1")
+			if err == nil {
+				t.Fatalf("accepted ID token of %s", identity.Subject)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestJWKPublicKeyEd25519Length(t *testing.T) {
+	for _, size := range []int{0, ed25519.PublicKeySize - 1, ed25519.PublicKeySize + 1} {
+		jwk := JWK{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(make([]byte, size))}
+		if _, err := jwk.PublicKey(); err == nil {
+			t.Errorf("accepted an Ed25519 key of %d bytes", size)
+		}
+	}
+	jwk := JWK{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(make([]byte, ed25519.PublicKeySize))}
+	if _, err := jwk.PublicKey(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCIdentityUsername(t *testing.T) {
+	a := &OIDCIdentity{Issuer: "https://a.example", Subject: "1"}
+	b := &OIDCIdentity{Issuer: "https://b.example", Subject: "1"}
+	if a.Username() == b.Username() {
+		t.Fatal("the same subject of two issuers got the same username")
+	}
+	if a.Username() != (&OIDCIdentity{Issuer: a.Issuer, Subject: a.Subject, Name: "renamed"}).Username() {
+		t.Fatal("username depends on more than the issuer and subject")
+	}
+}
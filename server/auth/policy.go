@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/issy20/go-websocket/config"
+)
+
+var (
+	ErrPasswordTooShort   = errors.New("password is too short")
+	ErrPasswordTooLong    = errors.New("password is too long")
+	ErrPasswordNoLetter   = errors.New("password must contain a letter")
+	ErrPasswordNoDigit    = errors.New("password must contain a digit")
+	ErrPasswordNoSymbol   = errors.New("password must contain a symbol")
+	ErrPasswordIsUsername = errors.New("password must not be the username")
+)
+
+var passwordConfig *config.PasswordConfig
+
+// UsePasswordConfig sets the policy CheckPassword enforces and the argon2
+// parameters GeneratePassword uses.
+func UsePasswordConfig(c *config.PasswordConfig) {
+	passwordConfig = c
+	hashParams = &PasswordConfig{
+		time:    c.HashTime,
+		memory:  c.HashMemory,
+		threads: c.HashThreads,
+		keyLen:  c.HashKeyLen,
+	}
+}
+
+// CheckPassword reports the first rule of the password policy that password
+// breaks.
+func CheckPassword(password string, username string) error {
+	c := passwordConfig
+	length := utf8.RuneCountInString(password)
+	if length < c.MinLength {
+		return ErrPasswordTooShort
+	}
+	if length > c.MaxLength {
+		return ErrPasswordTooLong
+	}
+	if username != "" && strings.EqualFold(password, username) {
+		return ErrPasswordIsUsername
+	}
+
+	var letter, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			letter = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	switch {
+	case c.RequireLetter && !letter:
+		return ErrPasswordNoLetter
+	case c.RequireDigit && !digit:
+		return ErrPasswordNoDigit
+	case c.RequireSymbol && !symbol:
+		return ErrPasswordNoSymbol
+	}
+	return nil
+}
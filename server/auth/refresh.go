@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/issy20/go-websocket/models"
+)
+
+var sessions models.AuthSessionRepository
+
+// UseSessionRepository sets where ValidateToken looks up revoked sessions.
+func UseSessionRepository(repository models.AuthSessionRepository) {
+	sessions = repository
+}
+
+// NewRefreshToken returns an opaque refresh token and the hash to store for it.
+func NewRefreshToken() (token string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashRefreshToken(token), nil
+}
+
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueRefreshToken stores a new refresh token for the session and returns it.
+func IssueRefreshToken(sessionID string) (string, error) {
+	token, hash, err := NewRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	expiresAt := time.Now().Add(jwtConfig.RefreshTokenTTL)
+	if err := sessions.AddRefreshToken(hash, sessionID, expiresAt); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// AccessTokenTTL is the lifetime of the access tokens CreateJWTToken issues.
+func AccessTokenTTL() time.Duration {
+	return jwtConfig.AccessTokenTTL
+}
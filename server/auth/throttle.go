@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+const throttlePruneInterval = time.Minute
+
+type attempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Throttle slows down and then locks out a key, such as a username or a
+// client address, after failed attempts. Failures are forgotten once a key
+// has had none for the lockout duration. State is kept per node.
+type Throttle struct {
+	// free failures are allowed before backoff starts.
+	free        int
+	maxFailures int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	lockout     time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]*attempts
+	lastPrune time.Time
+}
+
+func NewThrottle(free int, maxFailures int, backoff time.Duration, maxBackoff time.Duration, lockout time.Duration) *Throttle {
+	return &Throttle{
+		free:        free,
+		maxFailures: maxFailures,
+		backoff:     backoff,
+		maxBackoff:  maxBackoff,
+		lockout:     lockout,
+		keys:        make(map[string]*attempts),
+	}
+}
+
+// Wait returns how long key has to wait before its next attempt.
+func (t *Throttle) Wait(key string) time.Duration {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a, ok := t.keys[key]
+	if !ok {
+		return 0
+	}
+	if now.Before(a.lockedUntil) {
+		return a.lockedUntil.Sub(now)
+	}
+	if a.failures <= t.free {
+		return 0
+	}
+	delay := t.backoff << (a.failures - t.free - 1)
+	if delay > t.maxBackoff || delay <= 0 {
+		delay = t.maxBackoff
+	}
+	if wait := a.lastFailure.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Failure records a failed attempt. It reports true when this failure
+// locked the key.
+func (t *Throttle) Failure(key string) bool {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(now)
+
+	a, ok := t.keys[key]
+	if !ok || now.Sub(a.lastFailure) > t.lockout {
+		a = &attempts{}
+		t.keys[key] = a
+	}
+	a.failures++
+	a.lastFailure = now
+	if a.failures >= t.maxFailures {
+		a.failures = 0
+		a.lockedUntil = now.Add(t.lockout)
+		return true
+	}
+	return false
+}
+
+// Success forgets the failures of key.
+func (t *Throttle) Success(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.keys, key)
+}
+
+func (t *Throttle) prune(now time.Time) {
+	if now.Sub(t.lastPrune) < throttlePruneInterval {
+		return
+	}
+	t.lastPrune = now
+	for key, a := range t.keys {
+		if now.After(a.lockedUntil) && now.Sub(a.lastFailure) > t.lockout {
+			delete(t.keys, key)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/issy20/go-websocket/models"
+)
+
+const memoryChannelSize = 256
+
+var ErrBrokerClosed = errors.New("broker closed")
+
+// MemoryBroker delivers messages within a single process. It suits
+// single-node deployments and tests.
+type MemoryBroker struct {
+	mu       sync.RWMutex
+	channels map[string]map[*memorySubscription]bool
+	closed   bool
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{channels: make(map[string]map[*memorySubscription]bool)}
+}
+
+type memorySubscription struct {
+	channel  string
+	messages chan []byte
+}
+
+func (s *memorySubscription) Messages() <-chan []byte {
+	return s.messages
+}
+
+// Publish never blocks: like Redis it drops messages for a subscriber whose
+// buffer is full.
+func (b *MemoryBroker) Publish(ctx context.Context, channel string, message []byte) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrBrokerClosed
+	}
+	for sub := range b.channels[channel] {
+		select {
+		case sub.messages <- message:
+		default:
+			log.Printf("broker: %s channel is full (message is dropped)", channel)
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(ctx context.Context, channel string) (models.Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrBrokerClosed
+	}
+	sub := &memorySubscription{channel: channel, messages: make(chan []byte, memoryChannelSize)}
+	if b.channels[channel] == nil {
+		b.channels[channel] = make(map[*memorySubscription]bool)
+	}
+	b.channels[channel][sub] = true
+	return sub, nil
+}
+
+func (b *MemoryBroker) Unsubscribe(sub models.Subscription) error {
+	s, ok := sub.(*memorySubscription)
+	if !ok {
+		return fmt.Errorf("not a memory subscription: %T", sub)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if subs, ok := b.channels[s.channel]; ok && subs[s] {
+		delete(subs, s)
+		if len(subs) == 0 {
+			delete(b.channels, s.channel)
+		}
+		close(s.messages)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for _, subs := range b.channels {
+		for sub := range subs {
+			close(sub.messages)
+		}
+	}
+	b.channels = nil
+	return nil
+}
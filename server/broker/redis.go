@@ -0,0 +1,78 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/issy20/go-websocket/models"
+)
+
+// RedisBroker fans messages out between nodes with Redis PUB/SUB.
+type RedisBroker struct {
+	client *redis.Client
+}
+
+func NewRedisBroker(client *redis.Client) *RedisBroker {
+	return &RedisBroker{client: client}
+}
+
+type redisSubscription struct {
+	pubsub    *redis.PubSub
+	messages  chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *redisSubscription) Messages() <-chan []byte {
+	return s.messages
+}
+
+func (s *redisSubscription) forward() {
+	defer close(s.messages)
+	for msg := range s.pubsub.Channel() {
+		select {
+		case s.messages <- []byte(msg.Payload):
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, channel string, message []byte) error {
+	return b.client.Publish(ctx, channel, message).Err()
+}
+
+func (b *RedisBroker) Subscribe(ctx context.Context, channel string) (models.Subscription, error) {
+	pubsub := b.client.Subscribe(ctx, channel)
+	// Wait for the confirmation so that connection errors surface here.
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", channel, err)
+	}
+	sub := &redisSubscription{
+		pubsub:   pubsub,
+		messages: make(chan []byte),
+		done:     make(chan struct{}),
+	}
+	go sub.forward()
+	return sub, nil
+}
+
+func (b *RedisBroker) Unsubscribe(sub models.Subscription) error {
+	s, ok := sub.(*redisSubscription)
+	if !ok {
+		return fmt.Errorf("not a redis subscription: %T", sub)
+	}
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.pubsub.Close()
+	})
+	return err
+}
+
+func (b *RedisBroker) Close() error {
+	return b.client.Close()
+}
@@ -0,0 +1,170 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/issy20/go-websocket/models"
+)
+
+const (
+	streamKeyPrefix   = "stream:"
+	streamPayloadKey  = "payload"
+	streamReadCount   = 100
+	streamBlock       = 5 * time.Second
+	streamRetryDelay  = time.Second
+	streamPendingFrom = "0"
+	streamNewFrom     = ">"
+)
+
+// StreamBroker fans messages out through Redis Streams. Every node reads
+// each stream through its own consumer group, so entries published while a
+// node is disconnected are delivered once it reads again, and entries that
+// were read but never acknowledged are replayed after a restart.
+type StreamBroker struct {
+	client *redis.Client
+	// group names this node's consumer group and consumer.
+	group  string
+	maxLen int64
+}
+
+func NewStreamBroker(client *redis.Client, nodeID string, maxLen int64) *StreamBroker {
+	return &StreamBroker{client: client, group: nodeID, maxLen: maxLen}
+}
+
+type streamSubscription struct {
+	stream    string
+	messages  chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *streamSubscription) Messages() <-chan []byte {
+	return s.messages
+}
+
+func (b *StreamBroker) Publish(ctx context.Context, channel string, message []byte) error {
+	return b.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: streamKeyPrefix + channel,
+		MaxLen: b.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{streamPayloadKey: message},
+	}).Err()
+}
+
+func (b *StreamBroker) Subscribe(ctx context.Context, channel string) (models.Subscription, error) {
+	stream := streamKeyPrefix + channel
+	if err := b.createGroup(ctx, stream); err != nil {
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", channel, err)
+	}
+	sub := &streamSubscription{
+		stream:   stream,
+		messages: make(chan []byte),
+		done:     make(chan struct{}),
+	}
+	go b.consume(sub)
+	return sub, nil
+}
+
+// createGroup starts a new group at the end of the stream. An existing group
+// keeps its position so that nothing published meanwhile is lost.
+func (b *StreamBroker) createGroup(ctx context.Context, stream string) error {
+	err := b.client.XGroupCreateMkStream(ctx, stream, b.group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+// consume first replays the entries this consumer read but never
+// acknowledged, then follows new entries. Read errors restart from the
+// pending entries after a short delay.
+func (b *StreamBroker) consume(sub *streamSubscription) {
+	defer close(sub.messages)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-sub.done
+		cancel()
+	}()
+
+	from := streamPendingFrom
+	for {
+		streams, err := b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    b.group,
+			Consumer: b.group,
+			Streams:  []string{sub.stream, from},
+			Count:    streamReadCount,
+			Block:    streamBlock,
+		}).Result()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil && !errors.Is(err, redis.Nil) {
+			log.Printf("broker: reading %s: %s", sub.stream, err)
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				if err := b.createGroup(ctx, sub.stream); err != nil {
+					log.Printf("broker: recreating group on %s: %s", sub.stream, err)
+				}
+			}
+			select {
+			case <-time.After(streamRetryDelay):
+			case <-sub.done:
+				return
+			}
+			from = streamPendingFrom
+			continue
+		}
+
+		read := 0
+		for _, stream := range streams {
+			for _, entry := range stream.Messages {
+				read++
+				if !b.deliver(ctx, sub, entry) {
+					return
+				}
+			}
+		}
+		// Once the pending entries are drained, follow new ones.
+		if from == streamPendingFrom && read == 0 {
+			from = streamNewFrom
+		}
+	}
+}
+
+// deliver hands entry to the local consumer and acknowledges it afterwards.
+// It reports false when the subscription was closed first.
+func (b *StreamBroker) deliver(ctx context.Context, sub *streamSubscription, entry redis.XMessage) bool {
+	if payload, ok := entry.Values[streamPayloadKey].(string); ok {
+		select {
+		case sub.messages <- []byte(payload):
+		case <-sub.done:
+			return false
+		}
+	} else {
+		log.Printf("broker: entry %s on %s has no payload", entry.ID, sub.stream)
+	}
+	if err := b.client.XAck(ctx, sub.stream, b.group, entry.ID).Err(); err != nil {
+		log.Printf("broker: acknowledging %s on %s: %s", entry.ID, sub.stream, err)
+	}
+	return true
+}
+
+func (b *StreamBroker) Unsubscribe(sub models.Subscription) error {
+	s, ok := sub.(*streamSubscription)
+	if !ok {
+		return fmt.Errorf("not a stream subscription: %T", sub)
+	}
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
+
+func (b *StreamBroker) Close() error {
+	return b.client.Close()
+}
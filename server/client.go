@@ -2,12 +2,16 @@ package main
 
 import (
 	"encoding/json"
+	"expvar"
+	"fmt"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/issy20/go-websocket/apierror"
 	"github.com/issy20/go-websocket/auth"
 	"github.com/issy20/go-websocket/config"
 	"github.com/issy20/go-websocket/models"
@@ -20,35 +24,72 @@ const (
 	maxMessageSize = 10000
 )
 
+// Application close codes sent to clients.
+const (
+	closeSlowConsumer   = 4000
+	closeSessionResumed = 4001
+	closeSessionRevoked = 4002
+)
+
 var (
 	newline = []byte{'\n'}
 	// space   = []byte{' '}
 )
 
+// sendQueueDrops counts messages discarded for slow consumers, keyed by policy.
+var sendQueueDrops = expvar.NewMap("send_queue_drops")
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  4096,
 	WriteBufferSize: 4096,
+	// Clients sending their token as a subprotocol offer this one too.
+	Subprotocols: []string{auth.WebSocketSubprotocol},
+	// CheckOrigin is replaced with the configured allow-list on startup.
 	CheckOrigin: func(r *http.Request) bool {
-		return true
+		return false
 	},
 }
 
 type Client struct {
-	ws     *websocket.Conn
-	hub    *Hub
-	sendCh chan []byte
-	ID     uuid.UUID `json:"id"`
-	Name   string    `json:"name"`
-	Rooms  map[*Room]bool
+	ws  *websocket.Conn
+	hub *Hub
+	// sendCh is a bounded queue drained by WriteLoop. Producers must go
+	// through send so that a full queue never blocks them.
+	sendCh   chan []byte
+	sendMu   sync.Mutex
+	sendDone bool
+	policy   config.SlowConsumerPolicy
+	ID       uuid.UUID `json:"id"`
+	// Name changes when the user updates their profile and is guarded by nameMu.
+	Name   string `json:"name"`
+	nameMu sync.RWMutex
+	Guest  bool `json:"guest,omitempty"`
+	Bot    bool `json:"bot,omitempty"`
+	// user is who the client authenticated as. API keys limit it to their scopes.
+	user models.IUser
+	// roomsMu guards Rooms, which is changed by the read loop and by private
+	// room invitations arriving on the hub's pubsub goroutine.
+	roomsMu sync.RWMutex
+	Rooms   map[*Room]bool `json:"-"`
+	// session is replaced on resume and, like Rooms, guarded by roomsMu.
+	session *Session
+	// authSessionID is the login session of the token the client connected
+	// with, if any.
+	authSessionID string
+	// closeCh carries the close frame the write loop sends before hanging up.
+	closeCh   chan []byte
+	closeOnce sync.Once
 }
 
 func NewClient(ws *websocket.Conn, hub *Hub, name string, ID string) *Client {
 	client := &Client{
-		Name:   name,
-		ws:     ws,
-		hub:    hub,
-		sendCh: make(chan []byte),
-		Rooms:  make(map[*Room]bool),
+		Name:    name,
+		ws:      ws,
+		hub:     hub,
+		sendCh:  make(chan []byte, hub.ClientConfig.SendQueueSize),
+		policy:  hub.ClientConfig.SlowConsumerPolicy,
+		Rooms:   make(map[*Room]bool),
+		closeCh: make(chan []byte, 1),
 	}
 	if ID != "" {
 		client.ID, _ = uuid.Parse(ID)
@@ -111,20 +152,89 @@ func (c *Client) WriteLoop() {
 			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+		case closeMessage := <-c.closeCh:
+			c.flush()
+			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			c.ws.WriteMessage(websocket.CloseMessage, closeMessage)
+			return
 		}
 	}
 }
 
+// flush writes out whatever is already queued for the client without waiting
+// for more.
+func (c *Client) flush() {
+	for {
+		select {
+		case message, ok := <-c.sendCh:
+			if !ok {
+				return
+			}
+			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.ws.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Close sends a close frame with the given code and reason once pending
+// messages are written, then drops the connection.
+func (c *Client) Close(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCh <- websocket.FormatCloseMessage(code, text)
+	})
+}
+
 func (c *Client) Disconnect() {
-	c.hub.UnregisterCh <- c
-	log.Print("disconnect", c.hub.UnregisterCh)
-	for room := range c.Rooms {
+	c.hub.DetachSession(c)
+	c.hub.UnregisterClient(c)
+	log.Print("disconnect ", c.GetId())
+	for _, room := range c.joinedRooms() {
 		room.UnregisterCh <- c
 	}
+	c.sendMu.Lock()
+	c.sendDone = true
 	close(c.sendCh)
+	c.sendMu.Unlock()
 	c.ws.Close()
 }
 
+// send queues message for the client without blocking. When the queue is
+// full the configured slow consumer policy decides what gets dropped.
+func (c *Client) send(message []byte) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if c.sendDone {
+		return
+	}
+
+	select {
+	case c.sendCh <- message:
+		return
+	default:
+	}
+
+	sendQueueDrops.Add(string(c.policy), 1)
+	switch c.policy {
+	case config.DropOldest:
+		select {
+		case <-c.sendCh:
+		default:
+		}
+		select {
+		case c.sendCh <- message:
+		default:
+		}
+	case config.DropNewest:
+	case config.Disconnect:
+		log.Printf("disconnecting slow consumer %s", c.GetId())
+		c.Close(closeSlowConsumer, "slow consumer")
+	}
+}
+
 func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	userCtxValue := r.Context().Value(auth.UserContextKey)
 	if userCtxValue == nil {
@@ -133,6 +243,10 @@ func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	}
 
 	user := userCtxValue.(models.IUser)
+	if !models.HasScope(user, models.ScopeChatRead) {
+		apierror.Write(w, r, http.StatusForbidden, "api key lacks the chat:read scope")
+		return
+	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -140,12 +254,27 @@ func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	client := NewClient(conn, hub, user.GetName(), user.GetId())
+	client.Guest = models.IsGuest(user)
+	client.Bot = models.IsBot(user)
+	client.user = user
+	if sessionUser, ok := user.(interface{ GetSessionId() string }); ok {
+		client.authSessionID = sessionUser.GetSessionId()
+	}
+	session, err := hub.NewSession(client)
+	if err != nil {
+		log.Println(err)
+		conn.Close()
+		return
+	}
+	client.session = session
 
 	go client.WriteLoop()
+	client.NotifySession()
+	if guest, ok := user.(*auth.AnonUser); ok {
+		client.NotifyGuestToken(guest)
+	}
+	hub.RegisterClient(client)
 	go client.ReadLoop()
-
-	hub.RegisterCh <- client
-
 }
 
 func (c *Client) HandleNewMessage(jsonMessage []byte) {
@@ -158,24 +287,94 @@ func (c *Client) HandleNewMessage(jsonMessage []byte) {
 
 	m.Sender = c
 
-	switch m.Action {
-	case SendMessageAction:
-		roomID := m.Target.GetId()
-		if room := c.hub.FindRoomByID(roomID); room != nil {
-			room.BroadcastCh <- &m
-		}
-	case JoinRoomAction:
-		c.HandleJoinRoomMessage(m)
-	case LeaveRoomAction:
-		c.HandleLeaveRoomMessage(m)
-	case JoinRoomPrivateAction:
-		c.HandleJoinRoomPrivateMessage(m)
+	if err := c.hub.Policy.Authorize(c, &m); err != nil {
+		c.SendError(m.Action, err.Code, err.Reason)
+		return
+	}
+
+	if handle, ok := clientActions[m.Action]; ok {
+		handle(c, m)
+	}
+}
+
+// clientActions handles the actions clients send, once Policy allowed
+// them. docs.go documents each of them.
+var clientActions = map[string]func(c *Client, message Message){
+	SendMessageAction:     (*Client).HandleSendMessage,
+	JoinRoomAction:        (*Client).HandleJoinRoomMessage,
+	LeaveRoomAction:       (*Client).HandleLeaveRoomMessage,
+	JoinRoomPrivateAction: (*Client).HandleJoinRoomPrivateMessage,
+	ResumeAction:          (*Client).HandleResumeMessage,
+	SetGuestPolicyAction:  (*Client).HandleSetGuestPolicyMessage,
+}
+
+func (c *Client) HandleSendMessage(message Message) {
+	room := c.hub.FindRoomByID(message.Target.GetId())
+	if room == nil {
+		return
+	}
+	if err := c.hub.PostMessage(room, &message); err != nil {
+		log.Println(err, "HandleSendMessage()")
+		c.SendError(message.Action, ErrorInternal, "could not send the message")
+	}
+}
+
+// HandleSetGuestPolicyMessage lets the owner of message.Target change what
+// guests may do there. Every node learns the new policy through the room channel.
+func (c *Client) HandleSetGuestPolicyMessage(message Message) {
+	room := c.hub.FindRoomByID(message.Target.GetId())
+	if room == nil {
+		return
+	}
+	if !models.ValidGuestPolicy(message.Message) {
+		c.SendError(message.Action, ErrorInvalid, "unknown guest policy")
+		return
+	}
+	if err := c.hub.RoomRepository.SetGuestPolicy(room.GetId(), message.Message); err != nil {
+		log.Println(err, "HandleSetGuestPolicyMessage()")
+		c.SendError(message.Action, ErrorInternal, "could not change the guest policy")
+		return
 	}
+	notice := &Message{
+		Action:  GuestPolicyAction,
+		Message: message.Message,
+		Target:  room,
+		Sender:  c,
+	}
+	room.PublishRoomMessage(notice.Encode())
+}
+
+// SendError reports a refused action back to the client.
+func (c *Client) SendError(action string, code string, reason string) {
+	message := &Message{
+		Action:  ErrorAction,
+		Message: reason,
+		Error:   &MessageError{Action: action, Code: code},
+	}
+	c.send(message.Encode())
+}
+
+// NotifyGuestToken hands a guest that connected with just a name the token
+// to reconnect with under the same identity.
+func (c *Client) NotifyGuestToken(guest *auth.AnonUser) {
+	token, err := auth.CreateGuestToken(guest)
+	if err != nil {
+		log.Println(err, "NotifyGuestToken()")
+		return
+	}
+	message := &Message{
+		Action:  GuestTokenAction,
+		Message: token,
+	}
+	c.send(message.Encode())
 }
 
 func (c *Client) HandleJoinRoomMessage(message Message) {
 	roomName := message.Message
-	c.JoinRoom(roomName, nil)
+	if _, err := c.JoinRoom(roomName, nil); err != nil {
+		log.Println(err, "HandleJoinRoomMessage()")
+		c.SendError(JoinRoomAction, ErrorInternal, "could not join the room")
+	}
 }
 
 func (c *Client) HandleLeaveRoomMessage(message Message) {
@@ -183,9 +382,10 @@ func (c *Client) HandleLeaveRoomMessage(message Message) {
 	if room == nil {
 		return
 	}
-	if _, ok := c.Rooms[room]; ok {
-		delete(c.Rooms, room)
-	}
+	c.roomsMu.Lock()
+	delete(c.Rooms, room)
+	c.roomsMu.Unlock()
+	c.session.removeRoom(room.GetId())
 	room.UnregisterCh <- c
 }
 
@@ -195,35 +395,133 @@ func (c *Client) HandleJoinRoomPrivateMessage(message Message) {
 		return
 	}
 
-	roomName := message.Message + c.ID.String()
+	roomName := directMessageRoomName(message.Message, c.ID.String())
 
-	joinedRoom := c.JoinRoom(roomName, target)
+	joinedRoom, err := c.JoinRoom(roomName, target)
+	if err != nil {
+		log.Println(err, "HandleJoinRoomPrivateMessage()")
+		c.SendError(JoinRoomPrivateAction, ErrorInternal, "could not join the room")
+		return
+	}
 	if joinedRoom != nil {
 		c.inviteTargetUser(target, joinedRoom)
 	}
 }
 
-func (c *Client) JoinRoom(roomName string, sender models.IUser) *Room {
-	room := c.hub.FindRoomByID(roomName)
-	if room == nil {
-		room = c.hub.CreateRoom(roomName, sender != nil)
+// directMessageRoomName names the private room between two users.
+func directMessageRoomName(targetID string, senderID string) string {
+	return targetID + senderID
+}
+
+// isDirectMessageRoomName reports whether name has the form
+// directMessageRoomName gives, two user IDs back to back.
+func isDirectMessageRoomName(name string) bool {
+	if len(name) != 72 {
+		return false
+	}
+	if _, err := uuid.Parse(name[:36]); err != nil {
+		return false
+	}
+	_, err := uuid.Parse(name[36:])
+	return err == nil
+}
+
+// HandleResumeMessage takes over the session named in message.Message and
+// re-attaches the client to its rooms, replaying messages after the room
+// cursors. Unknown or expired sessions get a resync notice instead.
+func (c *Client) HandleResumeMessage(message Message) {
+	session := c.hub.ResumeSession(message.Message, c)
+	if session == nil {
+		resync := &Message{Action: ResyncAction}
+		c.send(resync.Encode())
+		return
+	}
+
+	fresh := c.session
+	c.hub.sessions.Delete(fresh.Token)
+	for _, roomID := range fresh.roomIDs() {
+		session.addRoom(roomID)
+	}
+	c.roomsMu.Lock()
+	c.session = session
+	c.roomsMu.Unlock()
+	c.NotifySession()
+
+	for _, roomID := range session.roomIDs() {
+		room := c.hub.FindRoomByID(roomID)
+		if room == nil {
+			session.removeRoom(roomID)
+			id, _ := uuid.Parse(roomID)
+			resync := &Message{Action: ResyncAction, Target: &Room{ID: id}}
+			c.send(resync.Encode())
+			continue
+		}
+		if c.addRoom(room) {
+			room.resumeCh <- resumeRequest{client: c, after: message.Cursors[roomID]}
+		}
+	}
+
+	resumed := &Message{Action: ResumedAction, Message: session.Token}
+	c.send(resumed.Encode())
+}
+
+// NotifySession tells the client which token resumes its session.
+func (c *Client) NotifySession() {
+	message := &Message{
+		Action:  SessionAction,
+		Message: c.session.Token,
+	}
+	c.send(message.Encode())
+}
+
+// JoinRoom joins the room called roomName, creating it when needed. A
+// direct message passes the user on the other side as sender and only joins
+// a private room; a public room never stands in for one.
+func (c *Client) JoinRoom(roomName string, sender models.IUser) (*Room, error) {
+	room, err := c.hub.CreateRoom(roomName, sender != nil, c)
+	if err != nil {
+		return nil, err
 	}
 	if sender == nil && room.Private {
-		return nil
+		return nil, nil
 	}
-	if !c.IsInRoom(room) {
-		c.Rooms[room] = true
+	if sender != nil && !room.Private {
+		return nil, fmt.Errorf("direct message room %s is public", room.GetId())
+	}
+	if c.addRoom(room) {
 		room.RegisterCh <- c
 		c.NotifyRoomJoined(room, sender)
 	}
-	return room
+	return room, nil
 }
 
 func (client *Client) IsInRoom(room *Room) bool {
-	if _, ok := client.Rooms[room]; ok {
-		return true
+	client.roomsMu.RLock()
+	defer client.roomsMu.RUnlock()
+	_, ok := client.Rooms[room]
+	return ok
+}
+
+// addRoom marks room as joined and reports whether it was not joined before.
+func (c *Client) addRoom(room *Room) bool {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+	if _, ok := c.Rooms[room]; ok {
+		return false
+	}
+	c.Rooms[room] = true
+	c.session.addRoom(room.GetId())
+	return true
+}
+
+func (c *Client) joinedRooms() []*Room {
+	c.roomsMu.RLock()
+	defer c.roomsMu.RUnlock()
+	rooms := make([]*Room, 0, len(c.Rooms))
+	for room := range c.Rooms {
+		rooms = append(rooms, room)
 	}
-	return false
+	return rooms
 }
 
 func (c *Client) inviteTargetUser(target models.IUser, room *Room) {
@@ -234,7 +532,7 @@ func (c *Client) inviteTargetUser(target models.IUser, room *Room) {
 		Sender:  c,
 	}
 
-	if err := config.Redis.Publish(ctx, PubSubGeneralChannel, inviteMessage.Encode()).Err(); err != nil {
+	if err := c.hub.Broker.Publish(ctx, PubSubGeneralChannel, inviteMessage.Encode()); err != nil {
 		log.Println(err)
 	}
 }
@@ -245,7 +543,7 @@ func (client *Client) NotifyRoomJoined(room *Room, sender models.IUser) {
 		Target: room,
 		Sender: sender,
 	}
-	client.sendCh <- message.Encode()
+	client.send(message.Encode())
 }
 
 func (c *Client) GetId() string {
@@ -253,5 +551,24 @@ func (c *Client) GetId() string {
 }
 
 func (client *Client) GetName() string {
+	client.nameMu.RLock()
+	defer client.nameMu.RUnlock()
 	return client.Name
 }
+
+func (client *Client) setName(name string) {
+	client.nameMu.Lock()
+	defer client.nameMu.Unlock()
+	client.Name = name
+}
+
+// MarshalJSON encodes the client as a message sender. It reads the name
+// under its lock because clients are encoded from many goroutines.
+func (client *Client) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&Sender{
+		ID:    client.ID,
+		Name:  client.GetName(),
+		Guest: client.Guest,
+		Bot:   client.Bot,
+	})
+}
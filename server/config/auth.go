@@ -0,0 +1,52 @@
+package config
+
+import "time"
+
+type AuthConfig struct {
+	// AllowQueryToken accepts tokens in the ?bearer= query parameter, which
+	// leaks them into proxy and access logs. It is off unless legacy clients
+	// need it.
+	AllowQueryToken bool
+	// CookieName is the HttpOnly cookie /api/login sets on request.
+	CookieName string
+	// CookieSecure marks the session and OIDC state cookies Secure. It is
+	// configured rather than taken from the request, since TLS usually ends
+	// at a proxy.
+	CookieSecure bool
+	// GuestsEnabled lets users connect with just a name.
+	GuestsEnabled bool
+	// GuestTokenTTL is how long a guest keeps its identity across reconnects.
+	GuestTokenTTL time.Duration
+	// TOTPIssuer names the service in authenticator apps.
+	TOTPIssuer string
+	// MFATokenTTL is how long a user has to enter the second factor after
+	// the password.
+	MFATokenTTL time.Duration
+	// AdminUserIDs may manage other users, e.g. reset their 2FA.
+	AdminUserIDs []string
+}
+
+func (c *AuthConfig) IsAdmin(userID string) bool {
+	for _, id := range c.AdminUserIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAuthConfig reads AUTH_ALLOW_QUERY_TOKEN, AUTH_COOKIE_NAME,
+// AUTH_COOKIE_SECURE, AUTH_GUESTS_ENABLED, AUTH_GUEST_TOKEN_TTL,
+// AUTH_TOTP_ISSUER, AUTH_MFA_TOKEN_TTL and ADMIN_USER_IDS.
+func NewAuthConfig() *AuthConfig {
+	return &AuthConfig{
+		AllowQueryToken: getEnvBool("AUTH_ALLOW_QUERY_TOKEN", false),
+		CookieName:      getEnv("AUTH_COOKIE_NAME", "access_token"),
+		CookieSecure:    getEnvBool("AUTH_COOKIE_SECURE", true),
+		GuestsEnabled:   getEnvBool("AUTH_GUESTS_ENABLED", true),
+		GuestTokenTTL:   getEnvDuration("AUTH_GUEST_TOKEN_TTL", 30*24*time.Hour),
+		TOTPIssuer:      getEnv("AUTH_TOTP_ISSUER", "go-websocket"),
+		MFATokenTTL:     getEnvDuration("AUTH_MFA_TOKEN_TTL", 5*time.Minute),
+		AdminUserIDs:    getEnvList("ADMIN_USER_IDS"),
+	}
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	RedisBroker   = "redis"
+	StreamsBroker = "streams"
+	MemoryBroker  = "memory"
+)
+
+type BrokerConfig struct {
+	// Kind selects the pubsub transport: "redis" for PUB/SUB, "streams" for
+	// Redis Streams or "memory". The memory broker only works for a single node.
+	Kind string
+	// NodeID names this node's consumer group when Kind is "streams". It must
+	// be unique per node and stable across restarts.
+	NodeID string
+	// StreamMaxLen caps the approximate length of each stream.
+	StreamMaxLen int64
+}
+
+// NewBrokerConfig reads the pubsub transport from PUBSUB_BROKER, NODE_ID and
+// STREAM_MAX_LEN.
+func NewBrokerConfig() (*BrokerConfig, error) {
+	hostname, _ := os.Hostname()
+	c := &BrokerConfig{
+		Kind:         getEnv("PUBSUB_BROKER", RedisBroker),
+		NodeID:       getEnv("NODE_ID", hostname),
+		StreamMaxLen: int64(getEnvInt("STREAM_MAX_LEN", 10000)),
+	}
+	switch c.Kind {
+	case RedisBroker, MemoryBroker:
+	case StreamsBroker:
+		if c.NodeID == "" {
+			return nil, fmt.Errorf("NODE_ID is required for the streams broker")
+		}
+		if c.StreamMaxLen < 1 {
+			return nil, fmt.Errorf("STREAM_MAX_LEN must be positive: %d", c.StreamMaxLen)
+		}
+	default:
+		return nil, fmt.Errorf("unknown PUBSUB_BROKER: %s", c.Kind)
+	}
+	return c, nil
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/issy20/go-websocket/models"
+)
+
+// SlowConsumerPolicy decides what happens when a client's send queue is full.
+type SlowConsumerPolicy string
+
+const (
+	DropOldest SlowConsumerPolicy = "drop-oldest"
+	DropNewest SlowConsumerPolicy = "drop-newest"
+	Disconnect SlowConsumerPolicy = "disconnect"
+)
+
+type ClientConfig struct {
+	SendQueueSize      int
+	SlowConsumerPolicy SlowConsumerPolicy
+	// SessionRetention is how long a disconnected session can be resumed
+	// and how long rooms keep messages for replay.
+	SessionRetention time.Duration
+	// RoomHistorySize caps the number of messages a room keeps for replay.
+	RoomHistorySize int
+	// DefaultGuestPolicy applies to new rooms: "allowed", "read-only" or "denied".
+	DefaultGuestPolicy string
+	// RoomCreation is who may create rooms by joining them: "anyone",
+	// "registered" or "nobody".
+	RoomCreation string
+	// DirectMessages is who may start direct messages with users who have
+	// not chosen for themselves.
+	DirectMessages string
+}
+
+// NewClientConfig reads the per-connection settings from
+// WS_SEND_QUEUE_SIZE, WS_SLOW_CONSUMER_POLICY, WS_SESSION_RETENTION,
+// WS_ROOM_HISTORY_SIZE, WS_DEFAULT_GUEST_POLICY, WS_ROOM_CREATION and
+// WS_DIRECT_MESSAGES.
+func NewClientConfig() (*ClientConfig, error) {
+	c := &ClientConfig{
+		SendQueueSize:      getEnvInt("WS_SEND_QUEUE_SIZE", 256),
+		SlowConsumerPolicy: SlowConsumerPolicy(getEnv("WS_SLOW_CONSUMER_POLICY", string(DropOldest))),
+		SessionRetention:   getEnvDuration("WS_SESSION_RETENTION", 2*time.Minute),
+		RoomHistorySize:    getEnvInt("WS_ROOM_HISTORY_SIZE", 1000),
+		DefaultGuestPolicy: getEnv("WS_DEFAULT_GUEST_POLICY", models.GuestsAllowed),
+		RoomCreation:       getEnv("WS_ROOM_CREATION", models.AllowAnyone),
+		DirectMessages:     getEnv("WS_DIRECT_MESSAGES", models.AllowAnyone),
+	}
+	if c.SendQueueSize < 1 {
+		return nil, fmt.Errorf("WS_SEND_QUEUE_SIZE must be positive: %d", c.SendQueueSize)
+	}
+	if c.SessionRetention <= 0 {
+		return nil, fmt.Errorf("WS_SESSION_RETENTION must be positive: %s", c.SessionRetention)
+	}
+	if c.RoomHistorySize < 0 {
+		return nil, fmt.Errorf("WS_ROOM_HISTORY_SIZE must not be negative: %d", c.RoomHistorySize)
+	}
+	if !models.ValidGuestPolicy(c.DefaultGuestPolicy) {
+		return nil, fmt.Errorf("unknown WS_DEFAULT_GUEST_POLICY: %s", c.DefaultGuestPolicy)
+	}
+	if !models.ValidAllow(c.RoomCreation) {
+		return nil, fmt.Errorf("unknown WS_ROOM_CREATION: %s", c.RoomCreation)
+	}
+	if !models.ValidAllow(c.DirectMessages) {
+		return nil, fmt.Errorf("unknown WS_DIRECT_MESSAGES: %s", c.DirectMessages)
+	}
+	switch c.SlowConsumerPolicy {
+	case DropOldest, DropNewest, Disconnect:
+	default:
+		return nil, fmt.Errorf("unknown WS_SLOW_CONSUMER_POLICY: %s", c.SlowConsumerPolicy)
+	}
+	return c, nil
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+type JWTConfig struct {
+	// KeysDir holds one file per key named after its kid: "<kid>.pem" for
+	// RSA, ECDSA P-256 or Ed25519 keys and "<kid>.secret" for HMAC secrets.
+	// Files containing only a public key are used for verification only.
+	KeysDir string
+	// SigningKeyID is the kid of the key new tokens are signed with.
+	SigningKeyID string
+	// HMACSecret is used as a single HS256 key when KeysDir is not set.
+	HMACSecret string
+	// Issuer and Audience are written to the iss and aud claims of issued
+	// tokens and required on validation.
+	Issuer   string
+	Audience string
+	// ClockSkew is the leeway allowed when checking exp, nbf and iat.
+	ClockSkew time.Duration
+	// AccessTokenTTL is the lifetime of issued access tokens.
+	AccessTokenTTL time.Duration
+	// RefreshTokenTTL is the lifetime of each refresh token. Refreshing
+	// rotates the token and starts a new lifetime.
+	RefreshTokenTTL time.Duration
+}
+
+// NewJWTConfig reads the token keys from JWT_KEYS_DIR and JWT_SIGNING_KID,
+// or JWT_HMAC_SECRET for a single shared secret, and the claim settings from
+// JWT_ISSUER, JWT_AUDIENCE, JWT_CLOCK_SKEW, JWT_ACCESS_TOKEN_TTL and
+// JWT_REFRESH_TOKEN_TTL.
+func NewJWTConfig() (*JWTConfig, error) {
+	c := &JWTConfig{
+		KeysDir:         getEnv("JWT_KEYS_DIR", ""),
+		SigningKeyID:    getEnv("JWT_SIGNING_KID", ""),
+		HMACSecret:      getEnv("JWT_HMAC_SECRET", ""),
+		Issuer:          getEnv("JWT_ISSUER", "go-websocket"),
+		Audience:        getEnv("JWT_AUDIENCE", "go-websocket"),
+		ClockSkew:       getEnvDuration("JWT_CLOCK_SKEW", 30*time.Second),
+		AccessTokenTTL:  getEnvDuration("JWT_ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: getEnvDuration("JWT_REFRESH_TOKEN_TTL", 30*24*time.Hour),
+	}
+	if c.KeysDir == "" && c.HMACSecret == "" {
+		return nil, fmt.Errorf("either JWT_KEYS_DIR or JWT_HMAC_SECRET must be set")
+	}
+	if c.KeysDir != "" && c.SigningKeyID == "" {
+		return nil, fmt.Errorf("JWT_SIGNING_KID is required with JWT_KEYS_DIR")
+	}
+	return c, nil
+}
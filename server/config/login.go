@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+type LoginConfig struct {
+	// MaxFailures failed logins in a row lock a username for Lockout.
+	MaxFailures int
+	// IPMaxFailures failed logins lock a client address for Lockout. Half
+	// as many are allowed before the address is slowed down, so that users
+	// behind a shared address are not punished for a few typos.
+	IPMaxFailures int
+	// Backoff is the wait after the first failure. It doubles with every
+	// further failure up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Lockout    time.Duration
+	// HashConcurrency bounds the password hashes computed at once, and
+	// HashWait is how long a login waits for a free slot.
+	HashConcurrency int
+	HashWait        time.Duration
+	// TrustProxy takes the client address from X-Forwarded-For. Only enable
+	// it behind a proxy that sets the header.
+	TrustProxy bool
+}
+
+// NewLoginConfig reads LOGIN_MAX_FAILURES, LOGIN_IP_MAX_FAILURES,
+// LOGIN_BACKOFF, LOGIN_MAX_BACKOFF, LOGIN_LOCKOUT, PASSWORD_HASH_CONCURRENCY,
+// PASSWORD_HASH_WAIT and TRUST_PROXY.
+func NewLoginConfig() (*LoginConfig, error) {
+	c := &LoginConfig{
+		MaxFailures:     getEnvInt("LOGIN_MAX_FAILURES", 5),
+		IPMaxFailures:   getEnvInt("LOGIN_IP_MAX_FAILURES", 50),
+		Backoff:         getEnvDuration("LOGIN_BACKOFF", time.Second),
+		MaxBackoff:      getEnvDuration("LOGIN_MAX_BACKOFF", 30*time.Second),
+		Lockout:         getEnvDuration("LOGIN_LOCKOUT", 15*time.Minute),
+		HashConcurrency: getEnvInt("PASSWORD_HASH_CONCURRENCY", runtime.NumCPU()),
+		HashWait:        getEnvDuration("PASSWORD_HASH_WAIT", 5*time.Second),
+		TrustProxy:      getEnvBool("TRUST_PROXY", false),
+	}
+	if c.MaxFailures < 1 || c.IPMaxFailures < 1 {
+		return nil, fmt.Errorf("LOGIN_MAX_FAILURES and LOGIN_IP_MAX_FAILURES must be positive")
+	}
+	if c.HashConcurrency < 1 {
+		return nil, fmt.Errorf("PASSWORD_HASH_CONCURRENCY must be positive: %d", c.HashConcurrency)
+	}
+	return c, nil
+}
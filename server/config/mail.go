@@ -0,0 +1,45 @@
+package config
+
+import "fmt"
+
+const (
+	FileMailer = "file"
+	SMTPMailer = "smtp"
+)
+
+type MailConfig struct {
+	// Kind selects how mail is sent: "file" writes each message into Dir,
+	// which stands in for a mail server during development, "smtp" sends it
+	// through SMTPAddr.
+	Kind string
+	From string
+	Dir  string
+	// SMTPAddr is host:port. SMTPUsername may be empty for relays that do
+	// not authenticate.
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+}
+
+// NewMailConfig reads MAIL_SENDER, MAIL_FROM, MAIL_DIR, SMTP_ADDR,
+// SMTP_USERNAME and SMTP_PASSWORD.
+func NewMailConfig() (*MailConfig, error) {
+	c := &MailConfig{
+		Kind:         getEnv("MAIL_SENDER", FileMailer),
+		From:         getEnv("MAIL_FROM", "no-reply@localhost"),
+		Dir:          getEnv("MAIL_DIR", "mail"),
+		SMTPAddr:     getEnv("SMTP_ADDR", ""),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+	}
+	switch c.Kind {
+	case FileMailer:
+	case SMTPMailer:
+		if c.SMTPAddr == "" {
+			return nil, fmt.Errorf("SMTP_ADDR is required for the smtp sender")
+		}
+	default:
+		return nil, fmt.Errorf("unknown MAIL_SENDER: %s", c.Kind)
+	}
+	return c, nil
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+type OIDCConfig struct {
+	// Issuer is the identity provider URL. OIDC login is disabled when empty.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// StateSecret signs the cookie that carries state, nonce and PKCE
+	// verifier between login and callback. It defaults to ClientSecret.
+	StateSecret string
+}
+
+func (c *OIDCConfig) Enabled() bool {
+	return c.Issuer != ""
+}
+
+// NewOIDCConfig reads OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET,
+// OIDC_REDIRECT_URL, OIDC_SCOPES and OIDC_STATE_SECRET.
+func NewOIDCConfig() (*OIDCConfig, error) {
+	c := &OIDCConfig{
+		Issuer:       strings.TrimSuffix(getEnv("OIDC_ISSUER", ""), "/"),
+		ClientID:     getEnv("OIDC_CLIENT_ID", ""),
+		ClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
+		RedirectURL:  getEnv("OIDC_REDIRECT_URL", ""),
+		Scopes:       strings.Fields(getEnv("OIDC_SCOPES", "openid profile email")),
+	}
+	c.StateSecret = getEnv("OIDC_STATE_SECRET", c.ClientSecret)
+	if !c.Enabled() {
+		return c, nil
+	}
+	if c.ClientID == "" || c.RedirectURL == "" {
+		return nil, fmt.Errorf("OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required with OIDC_ISSUER")
+	}
+	if c.StateSecret == "" {
+		return nil, fmt.Errorf("OIDC_STATE_SECRET is required without OIDC_CLIENT_SECRET")
+	}
+	return c, nil
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type OriginConfig struct {
+	// AllowedOrigins lists the browser origins allowed to open WebSocket
+	// connections and call the API, e.g. "https://chat.example.com" or
+	// "https://*.example.com" for any subdomain.
+	AllowedOrigins []string
+	// AllowAllOrigins disables the check. It is meant for local development.
+	AllowAllOrigins bool
+}
+
+// NewOriginConfig reads ALLOWED_ORIGINS, a comma separated list, and
+// ALLOW_ALL_ORIGINS.
+func NewOriginConfig() (*OriginConfig, error) {
+	c := &OriginConfig{
+		AllowAllOrigins: getEnvBool("ALLOW_ALL_ORIGINS", false),
+	}
+	for _, origin := range getEnvList("ALLOWED_ORIGINS") {
+		origin = strings.TrimRight(origin, "/")
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			return nil, fmt.Errorf("invalid origin in ALLOWED_ORIGINS: %s", origin)
+		}
+		c.AllowedOrigins = append(c.AllowedOrigins, strings.ToLower(origin))
+	}
+	return c, nil
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+type PasswordConfig struct {
+	MinLength int
+	MaxLength int
+	// RequireLetter, RequireDigit and RequireSymbol ask for at least one
+	// character of each kind.
+	RequireLetter bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// ResetTokenTTL is how long a password reset link can be used.
+	ResetTokenTTL time.Duration
+	// ResetURL is the page reset links point to. The token is appended as
+	// the token query parameter.
+	ResetURL string
+	// HashTime, HashMemory (in KiB), HashThreads and HashKeyLen are the
+	// argon2id parameters of new hashes. Stored hashes with weaker
+	// parameters are upgraded on the next login.
+	HashTime    uint32
+	HashMemory  uint32
+	HashThreads uint8
+	HashKeyLen  uint32
+}
+
+// NewPasswordConfig reads PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH,
+// PASSWORD_REQUIRE_LETTER, PASSWORD_REQUIRE_DIGIT, PASSWORD_REQUIRE_SYMBOL,
+// PASSWORD_RESET_TOKEN_TTL, PASSWORD_RESET_URL, PASSWORD_HASH_TIME,
+// PASSWORD_HASH_MEMORY, PASSWORD_HASH_THREADS and PASSWORD_HASH_KEY_LEN.
+func NewPasswordConfig() (*PasswordConfig, error) {
+	hashTime := getEnvInt("PASSWORD_HASH_TIME", 1)
+	hashMemory := getEnvInt("PASSWORD_HASH_MEMORY", 64*1024)
+	hashThreads := getEnvInt("PASSWORD_HASH_THREADS", 4)
+	hashKeyLen := getEnvInt("PASSWORD_HASH_KEY_LEN", 32)
+	// The ranges are checked before converting, so that negative or
+	// oversized values cannot wrap around into valid ones.
+	if hashTime < 1 || int64(hashTime) > math.MaxUint32 ||
+		hashThreads < 1 || hashThreads > math.MaxUint8 ||
+		hashMemory < 8*hashThreads || int64(hashMemory) > math.MaxUint32 ||
+		hashKeyLen < 16 || int64(hashKeyLen) > math.MaxUint32 {
+		return nil, fmt.Errorf("invalid argon2 parameters: t=%d m=%d p=%d len=%d", hashTime, hashMemory, hashThreads, hashKeyLen)
+	}
+
+	c := &PasswordConfig{
+		MinLength:     getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		MaxLength:     getEnvInt("PASSWORD_MAX_LENGTH", 128),
+		RequireLetter: getEnvBool("PASSWORD_REQUIRE_LETTER", true),
+		RequireDigit:  getEnvBool("PASSWORD_REQUIRE_DIGIT", true),
+		RequireSymbol: getEnvBool("PASSWORD_REQUIRE_SYMBOL", false),
+		ResetTokenTTL: getEnvDuration("PASSWORD_RESET_TOKEN_TTL", time.Hour),
+		ResetURL:      getEnv("PASSWORD_RESET_URL", ""),
+		HashTime:      uint32(hashTime),
+		HashMemory:    uint32(hashMemory),
+		HashThreads:   uint8(hashThreads),
+		HashKeyLen:    uint32(hashKeyLen),
+	}
+	if c.MinLength < 1 {
+		return nil, fmt.Errorf("PASSWORD_MIN_LENGTH must be positive: %d", c.MinLength)
+	}
+	if c.MaxLength < c.MinLength {
+		return nil, fmt.Errorf("PASSWORD_MAX_LENGTH must not be below PASSWORD_MIN_LENGTH: %d", c.MaxLength)
+	}
+	return c, nil
+}
@@ -0,0 +1,35 @@
+package config
+
+import "testing"
+
+func TestNewPasswordConfigHashParameters(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value string
+		valid bool
+	}{
+		{"defaults", "PASSWORD_HASH_TIME", "", true},
+		{"255 threads", "PASSWORD_HASH_THREADS", "255", true},
+		{"256 threads", "PASSWORD_HASH_THREADS", "256", false},
+		{"negative threads", "PASSWORD_HASH_THREADS", "-1", false},
+		{"negative memory", "PASSWORD_HASH_MEMORY", "-1", false},
+		{"memory above 32 bits", "PASSWORD_HASH_MEMORY", "4294967296", false},
+		{"negative time", "PASSWORD_HASH_TIME", "-1", false},
+		{"time above 32 bits", "PASSWORD_HASH_TIME", "4294967297", false},
+		{"negative key length", "PASSWORD_HASH_KEY_LEN", "-32", false},
+		{"short key", "PASSWORD_HASH_KEY_LEN", "8", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(tt.key, tt.value)
+			_, err := NewPasswordConfig()
+			if tt.valid && err != nil {
+				t.Fatal(err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatalf("accepted %s=%s", tt.key, tt.value)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+type WebhookConfig struct {
+	// MaxAttempts is how often a delivery is tried before it becomes a dead
+	// letter. The wait after the first failure is Backoff and doubles with
+	// every further failure up to MaxBackoff.
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	// Timeout bounds each delivery request.
+	Timeout time.Duration
+	// Workers deliver concurrently. Events beyond QueueSize waiting to be
+	// delivered are dropped.
+	Workers   int
+	QueueSize int
+	// Refresh is how often the webhooks are reloaded, so that changes made
+	// through other nodes take effect.
+	Refresh time.Duration
+	// IncomingRate is how many messages per minute each incoming webhook may
+	// post, with bursts of up to IncomingBurst. The limit is kept per node.
+	IncomingRate  int
+	IncomingBurst int
+}
+
+// NewWebhookConfig reads WEBHOOK_MAX_ATTEMPTS, WEBHOOK_BACKOFF,
+// WEBHOOK_MAX_BACKOFF, WEBHOOK_TIMEOUT, WEBHOOK_WORKERS, WEBHOOK_QUEUE_SIZE,
+// WEBHOOK_REFRESH, WEBHOOK_INCOMING_RATE and WEBHOOK_INCOMING_BURST.
+func NewWebhookConfig() (*WebhookConfig, error) {
+	c := &WebhookConfig{
+		MaxAttempts: getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		Backoff:     getEnvDuration("WEBHOOK_BACKOFF", 5*time.Second),
+		MaxBackoff:  getEnvDuration("WEBHOOK_MAX_BACKOFF", 30*time.Minute),
+		Timeout:     getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		Workers:     getEnvInt("WEBHOOK_WORKERS", 4),
+		QueueSize:   getEnvInt("WEBHOOK_QUEUE_SIZE", 1000),
+		Refresh:     getEnvDuration("WEBHOOK_REFRESH", 30*time.Second),
+
+		IncomingRate:  getEnvInt("WEBHOOK_INCOMING_RATE", 30),
+		IncomingBurst: getEnvInt("WEBHOOK_INCOMING_BURST", 10),
+	}
+	if c.MaxAttempts < 1 || c.Workers < 1 || c.QueueSize < 1 {
+		return nil, fmt.Errorf("WEBHOOK_MAX_ATTEMPTS, WEBHOOK_WORKERS and WEBHOOK_QUEUE_SIZE must be positive")
+	}
+	if c.Refresh <= 0 {
+		return nil, fmt.Errorf("WEBHOOK_REFRESH must be positive: %s", c.Refresh)
+	}
+	if c.IncomingRate < 1 || c.IncomingBurst < 1 {
+		return nil, fmt.Errorf("WEBHOOK_INCOMING_RATE and WEBHOOK_INCOMING_BURST must be positive")
+	}
+	return c, nil
+}
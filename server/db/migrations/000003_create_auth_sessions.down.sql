@@ -0,0 +1 @@
+DROP TABLE `auth_sessions`
\ No newline at end of file
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS `auth_sessions` (
+	`id` VARCHAR(255) NOT NULL PRIMARY KEY,
+	`user_id` VARCHAR(255) NOT NULL,
+	`created_at` DATETIME NOT NULL,
+	`revoked_at` DATETIME NULL
+) DEFAULT CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE `refresh_tokens`
\ No newline at end of file
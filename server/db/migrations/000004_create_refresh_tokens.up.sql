@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS `refresh_tokens` (
+	`token_hash` VARCHAR(64) NOT NULL PRIMARY KEY,
+	`session_id` VARCHAR(255) NOT NULL,
+	`expires_at` DATETIME NOT NULL,
+	`used_at` DATETIME NULL,
+	INDEX `refresh_tokens_session_id` (`session_id`)
+) DEFAULT CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci;
\ No newline at end of file
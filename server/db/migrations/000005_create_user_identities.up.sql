@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS `user_identities` (
+	`issuer` VARCHAR(255) NOT NULL,
+	`subject` VARCHAR(255) NOT NULL,
+	`user_id` VARCHAR(255) NOT NULL,
+	PRIMARY KEY (`issuer`, `subject`)
+) DEFAULT CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE `rooms` DROP COLUMN `guest_policy`, DROP COLUMN `owner_id`
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE `rooms`
+	ADD COLUMN `owner_id` VARCHAR(255) NULL,
+	ADD COLUMN `guest_policy` VARCHAR(16) NOT NULL DEFAULT 'allowed';
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE `users` DROP INDEX `users_email`, DROP COLUMN `email`
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE `users`
+	ADD COLUMN `email` VARCHAR(255) NULL,
+	ADD INDEX `users_email` (`email`);
\ No newline at end of file
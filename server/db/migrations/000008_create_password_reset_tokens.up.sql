@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS `password_reset_tokens` (
+	`token_hash` VARCHAR(64) NOT NULL PRIMARY KEY,
+	`user_id` VARCHAR(255) NOT NULL,
+	`expires_at` DATETIME NOT NULL,
+	`used_at` DATETIME NULL,
+	INDEX `password_reset_tokens_user_id` (`user_id`)
+) DEFAULT CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci;
\ No newline at end of file
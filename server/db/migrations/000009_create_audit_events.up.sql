@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `audit_events` (
+	`id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	`type` VARCHAR(64) NOT NULL,
+	`user_id` VARCHAR(255) NULL,
+	`username` VARCHAR(255) NOT NULL,
+	`ip` VARCHAR(64) NOT NULL,
+	`detail` VARCHAR(1024) NOT NULL,
+	`created_at` DATETIME NOT NULL,
+	INDEX `audit_events_type_created_at` (`type`, `created_at`),
+	INDEX `audit_events_user_id` (`user_id`)
+) DEFAULT CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE `users` DROP INDEX `users_owner_id`, DROP COLUMN `owner_id`, DROP COLUMN `kind`
\ No newline at end of file
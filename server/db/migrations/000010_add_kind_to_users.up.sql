@@ -0,0 +1,4 @@
+ALTER TABLE `users`
+	ADD COLUMN `kind` VARCHAR(16) NOT NULL DEFAULT 'user',
+	ADD COLUMN `owner_id` VARCHAR(255) NULL,
+	ADD INDEX `users_owner_id` (`owner_id`);
\ No newline at end of file
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS `api_keys` (
+	`id` VARCHAR(255) NOT NULL PRIMARY KEY,
+	`user_id` VARCHAR(255) NOT NULL,
+	`name` VARCHAR(255) NOT NULL,
+	`key_hash` VARCHAR(64) NOT NULL,
+	`scopes` VARCHAR(255) NOT NULL,
+	`created_at` DATETIME NOT NULL,
+	`expires_at` DATETIME NULL,
+	`revoked_at` DATETIME NULL,
+	UNIQUE INDEX `api_keys_key_hash` (`key_hash`),
+	INDEX `api_keys_user_id` (`user_id`)
+) DEFAULT CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci;
\ No newline at end of file
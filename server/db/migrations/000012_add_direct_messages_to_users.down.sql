@@ -0,0 +1 @@
+ALTER TABLE `users` DROP COLUMN `direct_messages`
\ No newline at end of file
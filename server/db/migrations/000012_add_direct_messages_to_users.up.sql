@@ -0,0 +1 @@
+ALTER TABLE `users` ADD COLUMN `direct_messages` VARCHAR(16) NULL;
\ No newline at end of file
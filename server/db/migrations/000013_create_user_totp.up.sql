@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS `user_totp` (
+	`user_id` VARCHAR(255) NOT NULL PRIMARY KEY,
+	`secret` VARCHAR(64) NOT NULL,
+	`enabled_at` DATETIME NULL,
+	`last_step` BIGINT NOT NULL DEFAULT 0,
+	`created_at` DATETIME NOT NULL
+) DEFAULT CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci;
\ No newline at end of file
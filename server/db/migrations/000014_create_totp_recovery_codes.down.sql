@@ -0,0 +1 @@
+DROP TABLE `totp_recovery_codes`
\ No newline at end of file
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS `totp_recovery_codes` (
+	`code_hash` VARCHAR(64) NOT NULL PRIMARY KEY,
+	`user_id` VARCHAR(255) NOT NULL,
+	`used_at` DATETIME NULL,
+	INDEX `totp_recovery_codes_user_id` (`user_id`)
+) DEFAULT CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci;
\ No newline at end of file
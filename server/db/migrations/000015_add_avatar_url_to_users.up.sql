@@ -0,0 +1 @@
+ALTER TABLE `users` ADD COLUMN `avatar_url` VARCHAR(1024) NULL;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE `messages`
\ No newline at end of file
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS `messages` (
+	`id` VARCHAR(255) NOT NULL PRIMARY KEY,
+	`room_id` VARCHAR(255) NOT NULL,
+	`sender_id` VARCHAR(255) NOT NULL,
+	`body` TEXT NOT NULL,
+	`created_at` DATETIME NOT NULL,
+	INDEX `messages_room_id_created_at` (`room_id`, `created_at`)
+) DEFAULT CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE `users` DROP INDEX `users_username`
\ No newline at end of file
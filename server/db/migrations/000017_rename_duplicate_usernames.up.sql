@@ -0,0 +1,4 @@
+UPDATE `users` AS `u`
+	JOIN `users` AS `kept` ON `kept`.`username` = `u`.`username` AND `kept`.`id` < `u`.`id`
+	SET `u`.`username` = CONCAT(LEFT(`u`.`username`, 218), ':', `u`.`id`);
+ALTER TABLE `users` ADD UNIQUE INDEX `users_username` (`username`);
\ No newline at end of file
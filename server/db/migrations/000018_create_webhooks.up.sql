@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS `webhooks` (
+	`id` VARCHAR(255) NOT NULL PRIMARY KEY,
+	`url` VARCHAR(2048) NOT NULL,
+	`secret` VARCHAR(255) NOT NULL,
+	`events` VARCHAR(255) NOT NULL,
+	`created_by` VARCHAR(255) NOT NULL,
+	`created_at` DATETIME NOT NULL
+) DEFAULT CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci;
\ No newline at end of file
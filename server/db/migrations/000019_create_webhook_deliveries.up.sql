@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS `webhook_deliveries` (
+	`id` VARCHAR(255) NOT NULL PRIMARY KEY,
+	`webhook_id` VARCHAR(255) NOT NULL,
+	`event` VARCHAR(64) NOT NULL,
+	`payload` MEDIUMTEXT NOT NULL,
+	`state` VARCHAR(16) NOT NULL,
+	`attempts` INT NOT NULL DEFAULT 0,
+	`last_status` INT NULL,
+	`last_error` VARCHAR(1024) NULL,
+	`created_at` DATETIME NOT NULL,
+	`updated_at` DATETIME NOT NULL,
+	INDEX `webhook_deliveries_webhook_id_state` (`webhook_id`, `state`, `created_at`),
+	INDEX `webhook_deliveries_state_updated_at` (`state`, `updated_at`)
+) DEFAULT CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE `incoming_webhooks`
\ No newline at end of file
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `incoming_webhooks` (
+	`id` VARCHAR(255) NOT NULL PRIMARY KEY,
+	`room_id` VARCHAR(255) NOT NULL,
+	`name` VARCHAR(255) NOT NULL,
+	`token_hash` VARCHAR(64) NOT NULL,
+	`created_by` VARCHAR(255) NOT NULL,
+	`created_at` DATETIME NOT NULL,
+	`revoked_at` DATETIME NULL,
+	UNIQUE INDEX `incoming_webhooks_token_hash` (`token_hash`),
+	INDEX `incoming_webhooks_room_id` (`room_id`)
+) DEFAULT CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci;
\ No newline at end of file
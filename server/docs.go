@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/issy20/go-websocket/apidoc"
+	"github.com/issy20/go-websocket/apierror"
+	"github.com/issy20/go-websocket/models"
+	"github.com/issy20/go-websocket/repository"
+)
+
+const (
+	apiTitle   = "go-websocket chat"
+	apiVersion = "1.0.0"
+)
+
+// Error statuses most operations answer with.
+var (
+	publicErrors = []int{http.StatusBadRequest, http.StatusMethodNotAllowed, http.StatusInternalServerError}
+	authErrors   = []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusMethodNotAllowed, http.StatusInternalServerError}
+)
+
+func withErrors(errors []int, more ...int) []int {
+	return append(append([]int{}, errors...), more...)
+}
+
+// operations documents the endpoints of apiRoutes.
+var operations = []apidoc.Operation{
+	{Method: http.MethodPost, Path: "/api/login", Summary: "Log in with username and password. Users with 2FA get an MFA challenge instead of tokens.",
+		Request: LoginUser{}, Response: apidoc.OneOf{TokenResponse{}, MFAResponse{}},
+		Errors: withErrors(publicErrors, http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusServiceUnavailable)},
+	{Method: http.MethodPost, Path: "/api/login/mfa", Summary: "Finish a login with a TOTP or recovery code.",
+		Request: MFALoginInput{}, Response: TokenResponse{},
+		Errors: withErrors(publicErrors, http.StatusUnauthorized, http.StatusTooManyRequests)},
+	{Method: http.MethodGet, Path: "/api/oidc/login", Summary: "Redirect to the identity provider. Only served when OIDC is configured.",
+		Query:  []apidoc.Param{{Name: "cookie", Type: "boolean", Description: "Also set the access token in an HttpOnly cookie after the callback."}},
+		Status: http.StatusFound, Errors: []int{http.StatusInternalServerError}},
+	{Method: http.MethodGet, Path: "/api/oidc/callback", Summary: "Finish an OIDC login. Users with 2FA get an MFA challenge instead of tokens.",
+		Query: []apidoc.Param{
+			{Name: "code", Type: "string", Description: "The authorization code."},
+			{Name: "state", Type: "string", Description: "The state of the login redirect."},
+		},
+		Response: apidoc.OneOf{TokenResponse{}, MFAResponse{}}, Errors: withErrors(publicErrors, http.StatusUnauthorized)},
+	{Method: http.MethodPost, Path: "/api/refresh", Summary: "Exchange a refresh token for new tokens.",
+		Request: RefreshInput{}, Response: TokenResponse{}, Errors: withErrors(publicErrors, http.StatusUnauthorized)},
+	{Method: http.MethodPost, Path: "/api/logout", Summary: "Revoke the session of a refresh token.",
+		Request: RefreshInput{}, Status: http.StatusNoContent, Errors: withErrors(publicErrors, http.StatusUnauthorized)},
+	{Method: http.MethodPost, Path: "/api/create", Summary: "Register an account.",
+		Request: UserInput{}, Status: http.StatusCreated, Response: UserResponse{},
+		Errors: withErrors(publicErrors, http.StatusConflict, http.StatusUnprocessableEntity)},
+	{Method: http.MethodPost, Path: "/api/guest", Summary: "Get a guest token.",
+		Request: GuestInput{}, Response: TokenResponse{}, Errors: withErrors(publicErrors, http.StatusForbidden)},
+	{Method: http.MethodPost, Path: "/api/guest/upgrade", Summary: "Turn the authenticated guest into a registered user with the same ID.", Auth: true,
+		Request: UserInput{}, Response: TokenResponse{}, Errors: withErrors(authErrors, http.StatusConflict, http.StatusUnprocessableEntity)},
+	{Method: http.MethodPost, Path: "/api/password", Summary: "Change the password of the authenticated user.", Auth: true,
+		Request: ChangePasswordInput{}, Status: http.StatusNoContent,
+		Errors: withErrors(authErrors, http.StatusUnprocessableEntity, http.StatusTooManyRequests, http.StatusServiceUnavailable)},
+	{Method: http.MethodPost, Path: "/api/password/reset", Summary: "Mail a password reset link. Always accepted.",
+		Request: ResetPasswordInput{}, Status: http.StatusAccepted, Errors: publicErrors},
+	{Method: http.MethodPost, Path: "/api/password/reset/confirm", Summary: "Set a new password with a reset token.",
+		Request: ConfirmResetInput{}, Status: http.StatusNoContent, Errors: withErrors(publicErrors, http.StatusUnprocessableEntity)},
+	{Method: http.MethodPost, Path: "/api/2fa/enroll", Summary: "Start TOTP enrolment.", Auth: true,
+		Response: TOTPEnrollResponse{}, Errors: authErrors},
+	{Method: http.MethodPost, Path: "/api/2fa/enable", Summary: "Enable TOTP with a first code and get recovery codes.", Auth: true,
+		Request: TOTPCodeInput{}, Response: RecoveryCodesResponse{}, Errors: withErrors(authErrors, http.StatusUnprocessableEntity)},
+	{Method: http.MethodPost, Path: "/api/2fa/disable", Summary: "Disable TOTP with the password and a code or recovery code.", Auth: true,
+		Request: TOTPCodeInput{}, Status: http.StatusNoContent,
+		Errors: withErrors(authErrors, http.StatusTooManyRequests, http.StatusServiceUnavailable)},
+	{Method: http.MethodPost, Path: "/api/admin/2fa/reset", Summary: "Remove the 2FA of a user. Admins only.", Auth: true,
+		Request: ResetTOTPInput{}, Status: http.StatusNoContent, Errors: withErrors(authErrors, http.StatusNotFound)},
+	{Method: http.MethodGet, Path: "/api/admin/webhooks", Summary: "List the outbound webhooks. Admins only.", Auth: true,
+		Response: []*models.Webhook{}, Errors: authErrors},
+	{Method: http.MethodPost, Path: "/api/admin/webhooks", Summary: "Register an outbound webhook. The signing secret is only returned here. Admins only.", Auth: true,
+		Request: WebhookInput{}, Status: http.StatusCreated, Response: WebhookResponse{}, Errors: withErrors(authErrors, http.StatusUnprocessableEntity)},
+	{Method: http.MethodPost, Path: "/api/admin/webhooks/delete", Summary: "Remove an outbound webhook. Admins only.", Auth: true,
+		Request: WebhookIDInput{}, Status: http.StatusNoContent, Errors: withErrors(authErrors, http.StatusNotFound)},
+	{Method: http.MethodGet, Path: "/api/admin/webhooks/deliveries", Summary: "List webhook deliveries, newest first. Admins only.", Auth: true,
+		Query: []apidoc.Param{
+			{Name: "webhook_id", Type: "string", Description: "Only deliveries of this webhook."},
+			{Name: "state", Type: "string", Description: "pending, delivered or dead."},
+			{Name: "limit", Type: "integer", Description: "Page size, at most 200."},
+		},
+		Response: []*models.WebhookDelivery{}, Errors: authErrors},
+	{Method: http.MethodPost, Path: "/api/admin/webhooks/deliveries/redeliver", Summary: "Send a delivery again with fresh attempts. Admins only.", Auth: true,
+		Request: WebhookIDInput{}, Status: http.StatusAccepted, Errors: withErrors(authErrors, http.StatusNotFound, http.StatusConflict)},
+	{Method: http.MethodGet, Path: "/api/service-accounts", Summary: "List the caller's service accounts.", Auth: true,
+		Response: []*repository.User{}, Errors: authErrors},
+	{Method: http.MethodPost, Path: "/api/service-accounts", Summary: "Create a service account.", Auth: true,
+		Request: ServiceAccountInput{}, Status: http.StatusCreated, Response: repository.User{}, Errors: withErrors(authErrors, http.StatusUnprocessableEntity)},
+	{Method: http.MethodGet, Path: "/api/service-accounts/keys", Summary: "List the API keys of a service account.", Auth: true,
+		Query:    []apidoc.Param{{Name: "service_account_id", Type: "string", Description: "The service account."}},
+		Response: []*models.APIKey{}, Errors: withErrors(authErrors, http.StatusNotFound)},
+	{Method: http.MethodPost, Path: "/api/service-accounts/keys", Summary: "Create an API key. The key is only returned here.", Auth: true,
+		Request: APIKeyInput{}, Status: http.StatusCreated, Response: APIKeyResponse{},
+		Errors: withErrors(authErrors, http.StatusNotFound, http.StatusUnprocessableEntity)},
+	{Method: http.MethodPost, Path: "/api/service-accounts/keys/revoke", Summary: "Revoke an API key.", Auth: true,
+		Request: RevokeAPIKeyInput{}, Status: http.StatusNoContent, Errors: withErrors(authErrors, http.StatusNotFound)},
+	{Method: http.MethodGet, Path: "/api/users", Summary: "List and search users.", Auth: true,
+		Query: []apidoc.Param{
+			{Name: "q", Type: "string", Description: "Searches names and usernames."},
+			{Name: "limit", Type: "integer", Description: "Page size, at most 200."},
+			{Name: "offset", Type: "integer", Description: "Number of users to skip."},
+		},
+		Response: UsersResponse{}, Errors: authErrors},
+	{Method: http.MethodGet, Path: "/api/users/{id}", Summary: "Get a user's profile. \"me\" is the authenticated user.", Auth: true,
+		Response: UserResponse{}, Errors: withErrors(authErrors, http.StatusNotFound)},
+	{Method: http.MethodPatch, Path: "/api/users/me", Summary: "Update the authenticated user's profile.", Auth: true,
+		Request: ProfileInput{}, Response: UserResponse{}, Errors: withErrors(authErrors, http.StatusUnprocessableEntity)},
+	{Method: http.MethodDelete, Path: "/api/users/me", Summary: "Delete the authenticated user's account.", Auth: true,
+		Status: http.StatusNoContent, Errors: authErrors},
+	{Method: http.MethodPost, Path: "/api/rooms/{id}/messages", Summary: "Send a message to a room the user owns or joined on a connection to the same node. Needs a token or API key.", Auth: true,
+		Request: PostMessageInput{}, Status: http.StatusCreated, Response: Message{},
+		Errors: withErrors(authErrors, http.StatusNotFound, http.StatusUnprocessableEntity)},
+	{Method: http.MethodGet, Path: "/api/rooms/{id}/webhooks", Summary: "List the incoming webhooks of a room. Room owners only.", Auth: true,
+		Response: []*models.IncomingWebhook{}, Errors: withErrors(authErrors, http.StatusNotFound)},
+	{Method: http.MethodPost, Path: "/api/rooms/{id}/webhooks", Summary: "Create an incoming webhook. Its URL is only returned here. Room owners only.", Auth: true,
+		Request: IncomingWebhookInput{}, Status: http.StatusCreated, Response: IncomingWebhookResponse{},
+		Errors: withErrors(authErrors, http.StatusNotFound, http.StatusUnprocessableEntity)},
+	{Method: http.MethodDelete, Path: "/api/rooms/{id}/webhooks/{webhook_id}", Summary: "Revoke the URL of an incoming webhook. Room owners only.", Auth: true,
+		Status: http.StatusNoContent, Errors: withErrors(authErrors, http.StatusNotFound)},
+	{Method: http.MethodPost, Path: "/api/hooks/{token}", Summary: "Post a message to the room of an incoming webhook. The token authenticates the request.",
+		Request: IncomingMessageInput{}, Status: http.StatusCreated, Response: Message{},
+		Errors: withErrors(publicErrors, http.StatusNotFound, http.StatusUnprocessableEntity, http.StatusTooManyRequests)},
+	{Method: http.MethodGet, Path: "/api/direct-messages", Summary: "Get who may start direct messages with the authenticated user.", Auth: true,
+		Response: DirectMessagesInput{}, Errors: authErrors},
+	{Method: http.MethodPut, Path: "/api/direct-messages", Summary: "Change who may start direct messages with the authenticated user.", Auth: true,
+		Request: DirectMessagesInput{}, Status: http.StatusNoContent, Errors: withErrors(authErrors, http.StatusUnprocessableEntity)},
+}
+
+// actions documents the frames of the WebSocket at /ws.
+var actions = []apidoc.Action{
+	{Name: SendMessageAction, Direction: apidoc.FromClient, Summary: "Send message to the room target, which the client has joined.", Fields: []string{"target", "message"}},
+	{Name: JoinRoomAction, Direction: apidoc.FromClient, Summary: "Join or create the public room named message.", Fields: []string{"message"}},
+	{Name: LeaveRoomAction, Direction: apidoc.FromClient, Summary: "Leave the room whose ID is message.", Fields: []string{"message"}},
+	{Name: JoinRoomPrivateAction, Direction: apidoc.FromClient, Summary: "Start a direct message room with the user whose ID is message.", Fields: []string{"message"}},
+	{Name: ResumeAction, Direction: apidoc.FromClient, Summary: "Resume the session whose token is message, replaying room messages after cursors.", Fields: []string{"message"}},
+	{Name: SetGuestPolicyAction, Direction: apidoc.FromClient, Summary: "Change what guests may do in target. Room owners only.", Fields: []string{"target", "message"}},
+
+	{Name: SendMessageAction, Direction: apidoc.FromServer, Summary: "A message in target. Chat messages have an id and a sender, join notices do not.", Fields: []string{"target", "message", "seq"}},
+	{Name: UserJoinedAction, Direction: apidoc.FromServer, Summary: "sender came online.", Fields: []string{"sender"}},
+	{Name: UserLeftAction, Direction: apidoc.FromServer, Summary: "sender went offline.", Fields: []string{"sender"}},
+	{Name: UserUpdatedAction, Direction: apidoc.FromServer, Summary: "sender changed their profile.", Fields: []string{"sender"}},
+	{Name: RoomJoinedAction, Direction: apidoc.FromServer, Summary: "The client joined target, invited by sender for direct messages.", Fields: []string{"target"}},
+	{Name: SessionAction, Direction: apidoc.FromServer, Summary: "message is the token that resumes this connection's session.", Fields: []string{"message"}},
+	{Name: ResumedAction, Direction: apidoc.FromServer, Summary: "The session whose token is message was resumed.", Fields: []string{"message"}},
+	{Name: ResyncAction, Direction: apidoc.FromServer, Summary: "Messages of target, or of every room without a target, are gone and must be fetched again."},
+	{Name: GuestTokenAction, Direction: apidoc.FromServer, Summary: "message is a guest token that keeps the guest's identity across reconnects.", Fields: []string{"message"}},
+	{Name: GuestPolicyAction, Direction: apidoc.FromServer, Summary: "The guest policy of target changed to message.", Fields: []string{"target", "message", "seq"}},
+	{Name: ErrorAction, Direction: apidoc.FromServer, Summary: "An action was refused; message says why.", Fields: []string{"error", "message"}},
+}
+
+func newSchemas(refPrefix string) *apidoc.Schemas {
+	schemas := apidoc.NewSchemas(refPrefix)
+	schemas.Override((*models.IUser)(nil), Sender{})
+	schemas.Override(&Client{}, Sender{})
+	return schemas
+}
+
+// HandleDocs serves doc, encoded once, as JSON.
+func HandleDocs(doc interface{}) http.HandlerFunc {
+	body, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Status(w, r, http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
+
+// OpenAPIDocument describes the /api endpoints.
+func OpenAPIDocument() map[string]interface{} {
+	return apidoc.OpenAPI(apiTitle, apiVersion, newSchemas("#/components/schemas/"), apierror.ErrorResponse{}, operations)
+}
+
+// AsyncAPIDocument describes the WebSocket actions.
+func AsyncAPIDocument() map[string]interface{} {
+	return apidoc.AsyncAPI(apiTitle, apiVersion, "/ws", newSchemas("#/components/schemas/"), Message{}, "action", actions)
+}
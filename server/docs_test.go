@@ -0,0 +1,104 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/issy20/go-websocket/apidoc"
+	"github.com/issy20/go-websocket/auth"
+	"github.com/issy20/go-websocket/config"
+)
+
+// TestOperationsMatchRoutes checks that every documented operation reaches
+// a route and that every route is documented.
+func TestOperationsMatchRoutes(t *testing.T) {
+	api := &API{OIDCProvider: &auth.OIDCProvider{}}
+	routes := api.apiRoutes(NewOrigins(&config.OriginConfig{}))
+	mux := http.NewServeMux()
+	for _, route := range routes {
+		mux.HandleFunc(route.pattern, route.handler)
+	}
+
+	pathParam := regexp.MustCompile(`\{[^}]+\}`)
+	documented := make(map[string]bool)
+	for _, op := range operations {
+		path := pathParam.ReplaceAllString(op.Path, "x")
+		_, pattern := mux.Handler(httptest.NewRequest(op.Method, path, nil))
+		if pattern == "" || pattern == "/api/" {
+			t.Errorf("%s %s is documented but not routed", op.Method, op.Path)
+		}
+		documented[pattern] = true
+	}
+	for _, route := range routes {
+		if route.pattern != "/api/" && !documented[route.pattern] {
+			t.Errorf("%s is routed but not documented", route.pattern)
+		}
+	}
+}
+
+// TestActionsMatchHandlers checks that the documented client actions are
+// the handled ones and that every action constant is documented.
+func TestActionsMatchHandlers(t *testing.T) {
+	documented := map[string]map[string]bool{apidoc.FromClient: {}, apidoc.FromServer: {}}
+	for _, action := range actions {
+		documented[action.Direction][action.Name] = true
+	}
+	for name := range clientActions {
+		if !documented[apidoc.FromClient][name] {
+			t.Errorf("client action %s is handled but not documented", name)
+		}
+	}
+	for name := range documented[apidoc.FromClient] {
+		if _, ok := clientActions[name]; !ok {
+			t.Errorf("client action %s is documented but not handled", name)
+		}
+	}
+
+	for name, value := range actionConstants(t) {
+		// Only travels between nodes.
+		if name == "SessionRevokedAction" {
+			continue
+		}
+		if !documented[apidoc.FromClient][value] && !documented[apidoc.FromServer][value] {
+			t.Errorf("%s (%s) is not documented", name, value)
+		}
+	}
+}
+
+// actionConstants returns the *Action constants of message.go by name.
+func actionConstants(t *testing.T) map[string]string {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "message.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	constants := make(map[string]string)
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			value := spec.(*ast.ValueSpec)
+			for i, name := range value.Names {
+				if !strings.HasSuffix(name.Name, "Action") || i >= len(value.Values) {
+					continue
+				}
+				if lit, ok := value.Values[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+					constants[name.Name], _ = strconv.Unquote(lit.Value)
+				}
+			}
+		}
+	}
+	if len(constants) == 0 {
+		t.Fatal("found no action constants in message.go")
+	}
+	return constants
+}
@@ -1,54 +1,83 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 	"github.com/issy20/go-websocket/config"
 	"github.com/issy20/go-websocket/models"
 )
 
 const PubSubGeneralChannel = "general"
 
+const shutdownReason = "going away, reconnect"
+
+// userEntry counts how many times a user has been announced so that a user
+// with several connections stays online until the last one leaves.
+type userEntry struct {
+	user models.IUser
+	refs int
+}
+
+// Hub keeps its state in sharded indexes so that client goroutines, room
+// loops and the pubsub listener can all use it without a central event loop.
 type Hub struct {
-	Users        []models.IUser
-	Clients      map[*Client]bool
-	RegisterCh   chan *Client
-	UnregisterCh chan *Client
-	// BroadcastCh    chan []byte
-	Rooms          map[*Room]bool
+	users       *shardedMap[*userEntry]
+	clients     *shardedMap[[]*Client]
+	roomsByID   *shardedMap[*Room]
+	roomsByName *shardedMap[*Room]
+	sessions    *shardedMap[*Session]
+	// roomLoadMu serialises loading rooms from the repository so that two
+	// clients joining an unknown room do not both create it.
+	roomLoadMu     sync.Mutex
 	RoomRepository models.RoomRepository
 	UserRepository models.UserRepository
+	// MessageRepository stores the chat messages sent to rooms.
+	MessageRepository models.MessageRepository
+	ClientConfig      *config.ClientConfig
+	Broker            models.Broker
+	// Policy authorizes the actions clients send.
+	Policy *Policy
+	// Events receives the chat events webhooks subscribe to. It may be nil.
+	Events models.EventEmitter
+
+	quitCh             chan struct{}
+	quitOnce           sync.Once
+	subscriptionDoneCh chan struct{}
 }
 
-func NewHub(roomRepository models.RoomRepository, userRepository models.UserRepository) *Hub {
+func NewHub(roomRepository models.RoomRepository, userRepository models.UserRepository, messageRepository models.MessageRepository, clientConfig *config.ClientConfig, broker models.Broker) *Hub {
 	hub := &Hub{
-		Clients:      make(map[*Client]bool),
-		RegisterCh:   make(chan *Client),
-		UnregisterCh: make(chan *Client),
-		// BroadcastCh:    make(chan []byte),
-		Rooms:          make(map[*Room]bool),
-		RoomRepository: roomRepository,
-		UserRepository: userRepository,
-	}
-	hub.Users = userRepository.GetAllUsers()
+		users:             newShardedMap[*userEntry](),
+		clients:           newShardedMap[[]*Client](),
+		roomsByID:         newShardedMap[*Room](),
+		roomsByName:       newShardedMap[*Room](),
+		sessions:          newShardedMap[*Session](),
+		RoomRepository:    roomRepository,
+		UserRepository:    userRepository,
+		MessageRepository: messageRepository,
+		ClientConfig:      clientConfig,
+		Broker:            broker,
+
+		quitCh:             make(chan struct{}),
+		subscriptionDoneCh: make(chan struct{}),
+	}
+	hub.Policy = NewPolicy(hub, clientConfig)
+	for _, user := range userRepository.GetAllUsers() {
+		hub.addUser(user)
+	}
 	return hub
 }
 
 func (h *Hub) RunLoop() {
-
-	go h.ListenPubSubChannel()
-	for {
-		select {
-		case client := <-h.RegisterCh:
-			h.RegisterClient(client)
-
-		case client := <-h.UnregisterCh:
-			h.UnregisterClient(client)
-		}
-	}
+	go h.expireSessions()
+	h.ListenPubSubChannel()
 }
 
 func (h *Hub) RegisterClient(client *Client) {
@@ -57,15 +86,51 @@ func (h *Hub) RegisterClient(client *Client) {
 	}
 
 	h.PublishClientJoined(client)
+	h.emit(models.EventUserJoined, &UserEvent{User: client})
 	h.ListOnlineClients(client)
-	h.Clients[client] = true
+	h.clients.Compute(client.GetId(), func(clients []*Client, _ bool) ([]*Client, bool) {
+		next := make([]*Client, 0, len(clients)+1)
+		next = append(next, clients...)
+		return append(next, client), true
+	})
 }
 
 func (h *Hub) UnregisterClient(client *Client) {
 	log.Print("UnregisterClient()")
-	if _, ok := h.Clients[client]; ok {
-		delete(h.Clients, client)
+	removed := false
+	h.clients.Compute(client.GetId(), func(clients []*Client, _ bool) ([]*Client, bool) {
+		next := make([]*Client, 0, len(clients))
+		for _, c := range clients {
+			if c == client {
+				removed = true
+				continue
+			}
+			next = append(next, c)
+		}
+		return next, len(next) > 0
+	})
+	if removed {
 		h.PublishClientLeft(client)
+		h.emit(models.EventUserLeft, &UserEvent{User: client})
+	}
+}
+
+// UserEvent is the data of user.joined and user.left webhook events.
+type UserEvent struct {
+	User models.IUser `json:"user"`
+}
+
+// RoomEvent is the data of room.created webhook events.
+type RoomEvent struct {
+	Room  *Room        `json:"room"`
+	Owner models.IUser `json:"owner"`
+}
+
+// emit hands a chat event to Events, when webhooks are set up. Events are
+// emitted by the node where they happen, so each is delivered once.
+func (h *Hub) emit(event string, data interface{}) {
+	if h.Events != nil {
+		h.Events.Emit(event, data)
 	}
 }
 
@@ -74,7 +139,7 @@ func (h *Hub) PublishClientJoined(client *Client) {
 		Action: UserJoinedAction,
 		Sender: client,
 	}
-	if err := config.Redis.Publish(ctx, PubSubGeneralChannel, message.Encode()).Err(); err != nil {
+	if err := h.Broker.Publish(ctx, PubSubGeneralChannel, message.Encode()); err != nil {
 		log.Println(err, "PublishClientJoined()")
 	}
 }
@@ -84,22 +149,60 @@ func (h *Hub) PublishClientLeft(client *Client) {
 		Action: UserLeftAction,
 		Sender: client,
 	}
-	if err := config.Redis.Publish(ctx, PubSubGeneralChannel, message.Encode()).Err(); err != nil {
+	if err := h.Broker.Publish(ctx, PubSubGeneralChannel, message.Encode()); err != nil {
 		log.Println(err, "PublishClientLeft()")
 	}
 }
 
+// PublishSessionRevoked tells every node to close the connections user opened
+// with tokens of the login session sessionID.
+func (h *Hub) PublishSessionRevoked(user models.IUser, sessionID string) {
+	message := &Message{
+		Action:  SessionRevokedAction,
+		Message: sessionID,
+		Sender:  user,
+	}
+	if err := h.Broker.Publish(ctx, PubSubGeneralChannel, message.Encode()); err != nil {
+		log.Println(err, "PublishSessionRevoked()")
+	}
+}
+
+// PublishUserUpdated tells every node and client the new profile of user.
+func (h *Hub) PublishUserUpdated(user models.IUser) {
+	message := &Message{
+		Action: UserUpdatedAction,
+		Sender: user,
+	}
+	if err := h.Broker.Publish(ctx, PubSubGeneralChannel, message.Encode()); err != nil {
+		log.Println(err, "PublishUserUpdated()")
+	}
+}
+
 func (h *Hub) ListenPubSubChannel() {
-	pubsub := config.Redis.Subscribe(ctx, PubSubGeneralChannel)
-	ch := pubsub.Channel()
+	defer close(h.subscriptionDoneCh)
+	sub, err := h.Broker.Subscribe(ctx, PubSubGeneralChannel)
+	if err != nil {
+		log.Println(err, "ListenPubSubChannel()")
+		return
+	}
+	defer h.Broker.Unsubscribe(sub)
 
-	log.Print(&ch)
+	for {
+		var payload []byte
+		select {
+		case p, ok := <-sub.Messages():
+			if !ok {
+				return
+			}
+			payload = p
+		case <-h.quitCh:
+			return
+		}
 
-	for msg := range ch {
 		var message Message
-		if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
+		if err := json.Unmarshal(payload, &message); err != nil {
 			log.Printf("ListenPubSubChannel: Error on unmarshal JSON message %s", err)
-			return
+			continue
 		}
 
 		switch message.Action {
@@ -109,121 +212,245 @@ func (h *Hub) ListenPubSubChannel() {
 			h.HandleUserLeft(message)
 		case JoinRoomPrivateAction:
 			h.HandleUserJoinPrivate(message)
+		case SessionRevokedAction:
+			h.HandleSessionRevoked(message)
+		case UserUpdatedAction:
+			h.HandleUserUpdated(message)
 		}
 	}
 }
 
 func (h *Hub) HandleUserJoined(message Message) {
-	h.Users = append(h.Users, message.Sender)
-	log.Print("hub.go", h)
+	h.addUser(message.Sender)
 	h.broadcastToAllClient(message.Encode())
 }
 
 func (h *Hub) HandleUserLeft(message Message) {
 	log.Print("HandleUserLeft")
-	for i, user := range h.Users {
-		if user.GetId() == message.Sender.GetId() {
-			h.Users[i] = h.Users[len(h.Users)-1]
-			h.Users = h.Users[:len(h.Users)-1]
-			break
-		}
-	}
+	h.removeUser(message.Sender)
 	h.broadcastToAllClient(message.Encode())
 }
 
+func (h *Hub) addUser(user models.IUser) {
+	h.users.Compute(user.GetId(), func(entry *userEntry, ok bool) (*userEntry, bool) {
+		if !ok {
+			return &userEntry{user: user, refs: 1}, true
+		}
+		return &userEntry{user: entry.user, refs: entry.refs + 1}, true
+	})
+}
+
+func (h *Hub) removeUser(user models.IUser) {
+	h.users.Compute(user.GetId(), func(entry *userEntry, ok bool) (*userEntry, bool) {
+		if !ok || entry.refs <= 1 {
+			return nil, false
+		}
+		return &userEntry{user: entry.user, refs: entry.refs - 1}, true
+	})
+}
+
 // messageのidとは？
 func (h *Hub) HandleUserJoinPrivate(message Message) {
 	targetClients := h.FindClientsByID(message.Message)
 	for _, targetClient := range targetClients {
-		targetClient.JoinRoom(message.Target.GetName(), message.Sender)
+		if _, err := targetClient.JoinRoom(message.Target.GetName(), message.Sender); err != nil {
+			log.Println(err, "HandleUserJoinPrivate()")
+		}
 	}
 }
 
+func (h *Hub) HandleSessionRevoked(message Message) {
+	for _, client := range h.FindClientsByID(message.Sender.GetId()) {
+		if client.authSessionID == message.Message {
+			client.Close(closeSessionRevoked, "session revoked")
+		}
+	}
+}
+
+// HandleUserUpdated renames the user's local connections, so that their
+// next messages carry the new name, and forwards the change to all clients.
+func (h *Hub) HandleUserUpdated(message Message) {
+	if message.Sender == nil {
+		return
+	}
+	h.users.Compute(message.Sender.GetId(), func(entry *userEntry, ok bool) (*userEntry, bool) {
+		if !ok {
+			return nil, false
+		}
+		return &userEntry{user: message.Sender, refs: entry.refs}, true
+	})
+	for _, client := range h.FindClientsByID(message.Sender.GetId()) {
+		client.setName(message.Sender.GetName())
+	}
+	h.broadcastToAllClient(message.Encode())
+}
+
+// ListOnlineClients tells client about every online user. The messages are
+// queued as one newline separated batch, the way the write loop coalesces
+// them, so that a long list does not overflow the bounded send queue.
 func (h *Hub) ListOnlineClients(client *Client) {
-	var uniqueUsers = make(map[string]bool)
-	fmt.Print(uniqueUsers)
-	for _, user := range h.Users {
-		if ok := uniqueUsers[user.GetId()]; !ok {
-			message := &Message{
-				Action: UserJoinedAction,
-				Sender: user,
-			}
-			uniqueUsers[user.GetId()] = true
-			client.sendCh <- message.Encode()
+	entries := h.users.Values()
+	if len(entries) == 0 {
+		return
+	}
+	messages := make([][]byte, 0, len(entries))
+	for _, entry := range entries {
+		message := &Message{
+			Action: UserJoinedAction,
+			Sender: entry.user,
 		}
+		messages = append(messages, message.Encode())
 	}
+	client.send(bytes.Join(messages, newline))
 }
 
 func (h *Hub) broadcastToAllClient(msg []byte) {
-	for c := range h.Clients {
-		c.sendCh <- msg
+	for _, clients := range h.clients.Values() {
+		for _, c := range clients {
+			c.send(msg)
+		}
 	}
 }
 
 func (h *Hub) FindRoomByName(name string) *Room {
-	var foundRoom *Room
-	for room := range h.Rooms {
-		if room.GetName() == name {
-			foundRoom = room
-			break
-		}
-	}
-	if foundRoom == nil {
-		foundRoom = h.RunRoomFromRepository(name)
+	if room, ok := h.roomsByName.Load(name); ok {
+		return room
 	}
-	return foundRoom
+	return h.RunRoomFromRepository(name)
 }
 
 func (h *Hub) RunRoomFromRepository(name string) *Room {
-	var room *Room
+	h.roomLoadMu.Lock()
+	defer h.roomLoadMu.Unlock()
+	if room, ok := h.roomsByName.Load(name); ok {
+		return room
+	}
+
 	dbRoom := h.RoomRepository.FindRoomByName(name)
-	if dbRoom != nil {
-		room = NewRoom(dbRoom.GetName(), dbRoom.GetPrivate())
-		room.ID, _ = uuid.Parse(dbRoom.GetId())
-		go room.RunRoom()
-		h.Rooms[room] = true
+	if dbRoom == nil {
+		return nil
 	}
+	room := NewRoom(dbRoom.GetName(), dbRoom.GetPrivate(), h.ClientConfig, h.Broker)
+	room.ID, _ = uuid.Parse(dbRoom.GetId())
+	room.OwnerID = dbRoom.GetOwnerId()
+	room.SetGuestPolicy(dbRoom.GetGuestPolicy())
+	h.addRoom(room)
+	go room.RunRoom()
 	return room
 }
 
 func (h *Hub) FindRoomByID(ID string) *Room {
-	var foundRoom *Room
-	for room := range h.Rooms {
-		if room.GetId() == ID {
-			foundRoom = room
-			break
-		}
+	room, _ := h.roomsByID.Load(ID)
+	return room
+}
+
+// LoadRoomByID is FindRoomByID for rooms that no client on this node has
+// joined yet; it runs them from the repository.
+func (h *Hub) LoadRoomByID(ID string) *Room {
+	if room := h.FindRoomByID(ID); room != nil {
+		return room
+	}
+	dbRoom := h.RoomRepository.FindRoomById(ID)
+	if dbRoom == nil {
+		return nil
 	}
+	return h.FindRoomByName(dbRoom.GetName())
+}
 
-	return foundRoom
+// PostMessage stores message as a chat message of room and publishes it on
+// the room channel, from which every node serving the room delivers it.
+func (h *Hub) PostMessage(room *Room, message *Message) error {
+	message.ID = uuid.New().String()
+	message.Action = SendMessageAction
+	message.Target = room
+	err := h.MessageRepository.AddMessage(&models.ChatMessage{
+		ID:        message.ID,
+		RoomID:    room.GetId(),
+		SenderID:  message.Sender.GetId(),
+		Body:      message.Message,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	room.PublishRoomMessage(message.Encode())
+	h.emit(models.EventMessageCreated, message)
+	return nil
 }
 
-func (h *Hub) CreateRoom(name string, private bool) *Room {
-	room := NewRoom(name, private)
-	h.RoomRepository.AddRoom(room)
+// CreateRoom returns the room called name, creating and persisting it with
+// owner when neither the hub nor the repository knows it yet.
+func (h *Hub) CreateRoom(name string, private bool, owner models.IUser) (*Room, error) {
+	if room := h.FindRoomByName(name); room != nil {
+		return room, nil
+	}
+
+	h.roomLoadMu.Lock()
+	defer h.roomLoadMu.Unlock()
+	if room, ok := h.roomsByName.Load(name); ok {
+		return room, nil
+	}
+
+	room := NewRoom(name, private, h.ClientConfig, h.Broker)
+	room.OwnerID = owner.GetId()
+	if err := h.RoomRepository.AddRoom(room); err != nil {
+		return nil, err
+	}
+	h.addRoom(room)
 	go room.RunRoom()
-	h.Rooms[room] = true
-	return room
+	h.emit(models.EventRoomCreated, &RoomEvent{Room: room, Owner: owner})
+	return room, nil
 }
 
-func (h *Hub) FindUserByID(ID string) models.IUser {
-	var foundUser models.IUser
-	for _, client := range h.Users {
-		if client.GetId() == ID {
-			foundUser = client
-			break
+func (h *Hub) addRoom(room *Room) {
+	h.roomsByID.Store(room.GetId(), room)
+	h.roomsByName.Store(room.GetName(), room)
+}
+
+// Shutdown stops listening to pubsub, closes every room subscription and asks
+// each connected client to reconnect elsewhere. It returns once all clients
+// have disconnected or ctx expires. New upgrades must already be refused,
+// e.g. by shutting the http.Server down first.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.quitOnce.Do(func() { close(h.quitCh) })
+	select {
+	case <-h.subscriptionDoneCh:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	for _, room := range h.roomsByID.Values() {
+		if err := room.Close(ctx); err != nil {
+			return err
 		}
 	}
-	return foundUser
-}
 
-func (h *Hub) FindClientsByID(ID string) []*Client {
-	var foundClients []*Client
-	for client := range h.Clients {
-		if client.ID.String() == ID {
-			foundClients = append(foundClients, client)
+	for _, clients := range h.clients.Values() {
+		for _, client := range clients {
+			client.Close(websocket.CloseGoingAway, shutdownReason)
+		}
+	}
 
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for h.clients.Len() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
-	return foundClients
+	return nil
+}
+
+func (h *Hub) FindUserByID(ID string) models.IUser {
+	if entry, ok := h.users.Load(ID); ok {
+		return entry.user
+	}
+	return nil
+}
+
+func (h *Hub) FindClientsByID(ID string) []*Client {
+	clients, _ := h.clients.Load(ID)
+	return clients
 }
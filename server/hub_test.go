@@ -0,0 +1,534 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/issy20/go-websocket/auth"
+	"github.com/issy20/go-websocket/broker"
+	"github.com/issy20/go-websocket/config"
+	"github.com/issy20/go-websocket/models"
+)
+
+func TestMain(m *testing.M) {
+	// Every join and leave is logged; thousands of them drown the results.
+	log.SetOutput(io.Discard)
+	// Test clients send no Origin header, which main's allow-list accepts.
+	upgrader.CheckOrigin = NewOrigins(&config.OriginConfig{}).CheckOrigin
+	// Guests get a token when they connect.
+	keySet, err := auth.NewKeySet("test", auth.NewHMACKey("test", []byte("test secret")))
+	if err != nil {
+		log.Fatal(err)
+	}
+	auth.UseKeySet(keySet)
+	auth.UseJWTConfig(&config.JWTConfig{AccessTokenTTL: time.Hour})
+	auth.UseAuthConfig(config.NewAuthConfig())
+	os.Exit(m.Run())
+}
+
+type fakeRoomRepository struct {
+	mu    sync.Mutex
+	rooms map[string]models.Room
+	adds  int
+}
+
+func (r *fakeRoomRepository) AddRoom(room models.Room) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rooms[room.GetId()] = room
+	r.adds++
+	return nil
+}
+
+func (r *fakeRoomRepository) FindRoomByName(name string) models.Room {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, room := range r.rooms {
+		if room.GetName() == name {
+			return room
+		}
+	}
+	return nil
+}
+
+func (r *fakeRoomRepository) FindRoomById(id string) models.Room {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rooms[id]
+}
+
+func (r *fakeRoomRepository) SetGuestPolicy(roomID string, policy string) error {
+	return nil
+}
+
+type fakeUserRepository struct{}
+
+func (fakeUserRepository) AddUser(id string, name string, username string, email string, password string) error {
+	return nil
+}
+func (fakeUserRepository) RemoveUser(user models.IUser)                         {}
+func (fakeUserRepository) FindUserById(ID string) models.IUser                  { return nil }
+func (fakeUserRepository) GetAllUsers() []models.IUser                          { return nil }
+func (fakeUserRepository) FindDirectMessagePolicy(id string) (string, error)    { return "", nil }
+func (fakeUserRepository) SetDirectMessagePolicy(id string, allow string) error { return nil }
+
+type fakeMessageRepository struct {
+	mu       sync.Mutex
+	messages int
+}
+
+func (r *fakeMessageRepository) AddMessage(message *models.ChatMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages++
+	return nil
+}
+
+type testUser struct {
+	id   string
+	name string
+}
+
+func (u testUser) GetId() string   { return u.id }
+func (u testUser) GetName() string { return u.name }
+
+func newTestHub(t *testing.T) (*Hub, *fakeRoomRepository, *fakeMessageRepository) {
+	t.Helper()
+	clientConfig, err := config.NewClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rooms := &fakeRoomRepository{rooms: make(map[string]models.Room)}
+	messages := &fakeMessageRepository{}
+	messageBroker := broker.NewMemoryBroker()
+	t.Cleanup(func() { messageBroker.Close() })
+	return NewHub(rooms, fakeUserRepository{}, messages, clientConfig, messageBroker), rooms, messages
+}
+
+// testServer serves ServeWs to the users connecting through dialAs, in
+// place of the auth middleware.
+type testServer struct {
+	*httptest.Server
+	users sync.Map
+}
+
+func newTestServer(t *testing.T, hub *Hub) *testServer {
+	t.Helper()
+	server := &testServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := server.users.Load(r.URL.Query().Get("id"))
+		if !ok {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		ServeWs(hub, w, r.WithContext(context.WithValue(r.Context(), auth.UserContextKey, user)))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// dial connects as the registered user id.
+func dial(server *testServer, id string) (*websocket.Conn, error) {
+	return dialAs(server, testUser{id: id, name: "user-" + id})
+}
+
+// dialAs connects as user. Closing the connection makes the server run
+// Client.Disconnect.
+func dialAs(server *testServer, user models.IUser) (*websocket.Conn, error) {
+	server.users.Store(user.GetId(), user)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "?id=" + user.GetId()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	return conn, err
+}
+
+// testMessage is the part of a server message the tests look at.
+type testMessage struct {
+	Action  string `json:"action"`
+	Message string `json:"message"`
+	Target  *struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"target"`
+	Error *struct {
+		Action string `json:"action"`
+		Code   string `json:"code"`
+	} `json:"error"`
+}
+
+// readUntil reads messages until match accepts one.
+func readUntil(conn *websocket.Conn, match func(message *testMessage) bool) error {
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		for _, line := range bytes.Split(data, newline) {
+			var message testMessage
+			if err := json.Unmarshal(line, &message); err != nil {
+				return err
+			}
+			if match(&message) {
+				return nil
+			}
+		}
+	}
+}
+
+// joinRoom asks to join the room called name, waits until the server
+// confirms it and returns the room's ID.
+func joinRoom(conn *websocket.Conn, name string) (string, error) {
+	join := &Message{Action: JoinRoomAction, Message: name}
+	if err := conn.WriteMessage(websocket.TextMessage, join.Encode()); err != nil {
+		return "", err
+	}
+	var roomID string
+	err := readUntil(conn, func(message *testMessage) bool {
+		if message.Action == RoomJoinedAction && message.Target != nil && message.Target.Name == name {
+			roomID = message.Target.ID
+			return true
+		}
+		return false
+	})
+	return roomID, err
+}
+
+// waitFor polls condition until it holds or a few seconds have passed.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHubConcurrentClients(t *testing.T) {
+	const (
+		clients = 400
+		users   = 100
+		rooms   = 8
+	)
+	hub, roomRepository, messageRepository := newTestHub(t)
+	server := newTestServer(t, hub)
+	go hub.ListenPubSubChannel()
+
+	ids := make([]string, users)
+	for i := range ids {
+		ids[i] = uuid.New().String()
+	}
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			id := ids[i%users]
+			conn, err := dial(server, id)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			name := fmt.Sprintf("room-%d", i%rooms)
+			roomID, err := joinRoom(conn, name)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			room := hub.FindRoomByID(roomID)
+			if room == nil {
+				t.Error("joined room is not indexed by ID")
+				return
+			}
+			if hub.FindRoomByName(name) != room {
+				t.Error("room lookup by name returned another room")
+			}
+			// Rooms broadcast while other clients of the room disconnect.
+			text := fmt.Sprintf("hello from %d", i)
+			send := fmt.Sprintf(`{"action":%q,"message":%q,"target":{"id":%q}}`, SendMessageAction, text, roomID)
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(send)); err != nil {
+				t.Error(err)
+				return
+			}
+			// Hanging up with unread messages resets the connection, which
+			// could drop the message before the server reads it.
+			if err := readUntil(conn, func(message *testMessage) bool { return message.Message == text }); err != nil {
+				t.Error(err)
+			}
+			joined := false
+			for _, client := range hub.FindClientsByID(id) {
+				joined = joined || client.IsInRoom(room)
+			}
+			if !joined {
+				t.Error("no client of the user is in the room it joined")
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	waitFor(t, "users still have clients after every connection closed", func() bool {
+		return hub.clients.Len() == 0
+	})
+	if n := hub.roomsByName.Len(); n != rooms {
+		t.Errorf("hub has %d rooms by name, want %d", n, rooms)
+	}
+	if n := hub.roomsByID.Len(); n != rooms {
+		t.Errorf("hub has %d rooms by ID, want %d", n, rooms)
+	}
+	roomRepository.mu.Lock()
+	if roomRepository.adds != rooms {
+		t.Errorf("%d rooms were persisted, want %d", roomRepository.adds, rooms)
+	}
+	roomRepository.mu.Unlock()
+	messageRepository.mu.Lock()
+	if messageRepository.messages != clients {
+		t.Errorf("%d messages were stored, want %d", messageRepository.messages, clients)
+	}
+	messageRepository.mu.Unlock()
+}
+
+func TestHubClientsOfOneUser(t *testing.T) {
+	hub, _, _ := newTestHub(t)
+	server := newTestServer(t, hub)
+	id := uuid.New().String()
+
+	first, err := dial(server, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	waitFor(t, "first client was not registered", func() bool {
+		return len(hub.FindClientsByID(id)) == 1
+	})
+	kept := hub.FindClientsByID(id)[0]
+
+	const connections = 200
+	conns := make([]*websocket.Conn, connections)
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := dial(server, id)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conns[i] = conn
+		}(i)
+	}
+	wg.Wait()
+	waitFor(t, "not every connection was registered", func() bool {
+		return len(hub.FindClientsByID(id)) == connections+1
+	})
+
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn *websocket.Conn) {
+			defer wg.Done()
+			conn.Close()
+		}(conn)
+	}
+	wg.Wait()
+	waitFor(t, "user kept the clients of closed connections", func() bool {
+		return len(hub.FindClientsByID(id)) == 1
+	})
+	if hub.FindClientsByID(id)[0] != kept {
+		t.Fatal("the remaining client is not the first one")
+	}
+
+	first.Close()
+	waitFor(t, "user still has clients after the last one left", func() bool {
+		return hub.FindClientsByID(id) == nil
+	})
+}
+
+func TestHubUserRefs(t *testing.T) {
+	hub, _, _ := newTestHub(t)
+	users := make([]testUser, 100)
+	for i := range users {
+		users[i] = testUser{id: uuid.New().String(), name: fmt.Sprintf("user-%d", i)}
+	}
+
+	// Every user comes online 20 times and leaves 19 times, concurrently.
+	var wg sync.WaitGroup
+	for _, user := range users {
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(user testUser) {
+				defer wg.Done()
+				hub.addUser(user)
+			}(user)
+		}
+	}
+	wg.Wait()
+	for _, user := range users {
+		for i := 0; i < 19; i++ {
+			wg.Add(1)
+			go func(user testUser) {
+				defer wg.Done()
+				hub.removeUser(user)
+			}(user)
+		}
+	}
+	wg.Wait()
+
+	for _, user := range users {
+		if hub.FindUserByID(user.id) == nil {
+			t.Fatalf("%s went offline while one connection is left", user.name)
+		}
+		hub.removeUser(user)
+		if hub.FindUserByID(user.id) != nil {
+			t.Fatalf("%s is online after every connection left", user.name)
+		}
+	}
+}
+
+func TestHubListsEveryOnlineUser(t *testing.T) {
+	hub, _, _ := newTestHub(t)
+	server := newTestServer(t, hub)
+	// More users than fit in a client's send queue.
+	users := 4 * hub.ClientConfig.SendQueueSize
+	for i := 0; i < users; i++ {
+		hub.addUser(testUser{id: uuid.New().String(), name: fmt.Sprintf("user-%d", i)})
+	}
+
+	conn, err := dial(server, uuid.New().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	listed := 0
+	err = readUntil(conn, func(message *testMessage) bool {
+		if message.Action == UserJoinedAction {
+			listed++
+		}
+		return listed == users
+	})
+	if err != nil {
+		t.Fatalf("listed %d of %d online users: %v", listed, users, err)
+	}
+}
+
+func TestHubCreateRoomOnce(t *testing.T) {
+	hub, roomRepository, _ := newTestHub(t)
+	owner := testUser{id: uuid.New().String(), name: "owner"}
+
+	const callers = 2000
+	created := make([]*Room, callers)
+	var wg sync.WaitGroup
+	for i := range created {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			room, err := hub.CreateRoom("lobby", false, owner)
+			if err != nil {
+				t.Error(err)
+			}
+			created[i] = room
+		}(i)
+	}
+	wg.Wait()
+
+	for _, room := range created {
+		if room != created[0] {
+			t.Fatal("concurrent CreateRoom calls returned different rooms")
+		}
+	}
+	if roomRepository.adds != 1 {
+		t.Fatalf("room was persisted %d times", roomRepository.adds)
+	}
+}
+
+func TestShardedMapCompute(t *testing.T) {
+	m := newShardedMap[int]()
+	const (
+		keys       = 64
+		increments = 5000
+	)
+	var wg sync.WaitGroup
+	for i := 0; i < increments; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m.Compute(fmt.Sprint(i%keys), func(old int, _ bool) (int, bool) {
+				return old + 1, true
+			})
+			m.Load(fmt.Sprint((i + 1) % keys))
+			m.Values()
+		}(i)
+	}
+	wg.Wait()
+
+	if n := m.Len(); n != keys {
+		t.Fatalf("map has %d keys, want %d", n, keys)
+	}
+	total := 0
+	for _, v := range m.Values() {
+		total += v
+	}
+	if total != increments {
+		t.Fatalf("counted %d increments, want %d", total, increments)
+	}
+
+	for i := 0; i < keys; i++ {
+		m.Compute(fmt.Sprint(i), func(int, bool) (int, bool) { return 0, false })
+	}
+	if n := m.Len(); n != 0 {
+		t.Fatalf("map has %d keys after removing all", n)
+	}
+}
+
+func TestHubShutdownWaitsForClients(t *testing.T) {
+	hub, _, _ := newTestHub(t)
+	server := newTestServer(t, hub)
+	go hub.ListenPubSubChannel()
+	conn, err := dial(server, uuid.New().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := joinRoom(conn, "lobby"); err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		// Hanging up on the close frame makes the server disconnect us.
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				conn.Close()
+				closed <- err
+				return
+			}
+		}
+	}()
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := hub.Shutdown(shutdownCtx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-closed; !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("connection ended with %v, want a going away close frame", err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/issy20/go-websocket/apierror"
+	"github.com/issy20/go-websocket/auth"
+	"github.com/issy20/go-websocket/models"
+)
+
+// incomingWebhookPath is followed by the token of an incoming webhook.
+const incomingWebhookPath = "/api/hooks/"
+
+type IncomingWebhookInput struct {
+	// Name is who the webhook's messages appear to come from.
+	Name string `json:"name"`
+}
+
+type IncomingWebhookResponse struct {
+	*models.IncomingWebhook
+	// URL is the path messages are posted to. It is only returned when the
+	// webhook is created.
+	URL string `json:"url"`
+}
+
+type IncomingMessageInput struct {
+	Message string `json:"message"`
+	// Text is read when Message is empty, as sent by tools that post
+	// Slack-style payloads.
+	Text string `json:"text"`
+}
+
+// roomOwner reports whether user owns room and may manage its webhooks.
+func roomOwner(w http.ResponseWriter, r *http.Request, user models.IUser, room *Room) bool {
+	if models.IsGuest(user) || models.IsBot(user) || room.GetOwnerId() != user.GetId() {
+		apierror.Write(w, r, http.StatusForbidden, "only the room owner may manage its webhooks")
+		return false
+	}
+	return true
+}
+
+// handleIncomingWebhooks lists the incoming webhooks of a room on GET and
+// creates one on POST. Room owners only.
+func (api *API) handleIncomingWebhooks(w http.ResponseWriter, r *http.Request, user models.IUser, room *Room) {
+	if !roomOwner(w, r, user, room) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		hooks, err := api.IncomingWebhookRepository.FindIncomingWebhooksByRoomId(room.GetId())
+		if err != nil {
+			log.Println(err, "handleIncomingWebhooks()")
+			apierror.Status(w, r, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hooks)
+
+	case http.MethodPost:
+		var input IncomingWebhookInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		input.Name = strings.TrimSpace(input.Name)
+		if !validName(input.Name) {
+			apierror.WriteField(w, r, "name", "must be between 1 and 255 characters")
+			return
+		}
+		token, hash, err := auth.NewRefreshToken()
+		if err != nil {
+			log.Println(err, "handleIncomingWebhooks()")
+			apierror.Status(w, r, http.StatusInternalServerError)
+			return
+		}
+		hook := &models.IncomingWebhook{
+			ID:        uuid.New().String(),
+			RoomID:    room.GetId(),
+			Name:      input.Name,
+			CreatedBy: user.GetId(),
+			CreatedAt: time.Now(),
+		}
+		if err := api.IncomingWebhookRepository.AddIncomingWebhook(hook, hash); err != nil {
+			log.Println(err, "handleIncomingWebhooks()")
+			apierror.Status(w, r, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(&IncomingWebhookResponse{IncomingWebhook: hook, URL: incomingWebhookPath + token})
+
+	default:
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+	}
+}
+
+// revokeIncomingWebhook revokes the URL of an incoming webhook on DELETE.
+// Room owners only.
+func (api *API) revokeIncomingWebhook(w http.ResponseWriter, r *http.Request, user models.IUser, room *Room, id string) {
+	if r.Method != http.MethodDelete {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	if !roomOwner(w, r, user, room) {
+		return
+	}
+	err := api.IncomingWebhookRepository.RevokeIncomingWebhook(id, room.GetId())
+	if errors.Is(err, models.ErrIncomingWebhookNotFound) {
+		apierror.Status(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err, "revokeIncomingWebhook()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleIncomingWebhook serves POST /api/hooks/{token}. The token is the
+// only credential; the message is posted to the webhook's room as a bot
+// named after the webhook and delivered like any other chat message.
+func (api *API) HandleIncomingWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.TrimPrefix(r.URL.Path, incomingWebhookPath)
+	hook, err := api.IncomingWebhookRepository.FindIncomingWebhookByHash(auth.HashRefreshToken(token))
+	if errors.Is(err, models.ErrIncomingWebhookNotFound) || errors.Is(err, models.ErrIncomingWebhookRevoked) {
+		apierror.Status(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err, "HandleIncomingWebhook()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	if wait := api.IncomingWebhookLimiter.Allow(hook.ID); wait > 0 {
+		writeRetryAfter(w, r, wait, http.StatusTooManyRequests)
+		return
+	}
+
+	var input IncomingMessageInput
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMessageSize)).Decode(&input); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if input.Message == "" {
+		input.Message = input.Text
+	}
+	if strings.TrimSpace(input.Message) == "" {
+		apierror.WriteField(w, r, "message", "must not be empty")
+		return
+	}
+	room := api.Hub.LoadRoomByID(hook.RoomID)
+	if room == nil {
+		apierror.Write(w, r, http.StatusNotFound, "room not found")
+		return
+	}
+
+	id, _ := uuid.Parse(hook.ID)
+	api.postMessage(w, r, room, &Message{
+		Message: input.Message,
+		Sender:  &Client{ID: id, Name: hook.Name, Bot: true},
+	})
+}
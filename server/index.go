@@ -0,0 +1,94 @@
+package main
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+const shardCount = 32
+
+type mapShard[V any] struct {
+	sync.RWMutex
+	items map[string]V
+}
+
+// shardedMap is a string keyed map split into independently locked shards
+// so that lookups for different keys do not contend on a single lock.
+type shardedMap[V any] struct {
+	shards [shardCount]*mapShard[V]
+}
+
+func newShardedMap[V any]() *shardedMap[V] {
+	m := &shardedMap[V]{}
+	for i := range m.shards {
+		m.shards[i] = &mapShard[V]{items: make(map[string]V)}
+	}
+	return m
+}
+
+func (m *shardedMap[V]) shard(key string) *mapShard[V] {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return m.shards[h.Sum32()%shardCount]
+}
+
+func (m *shardedMap[V]) Load(key string) (V, bool) {
+	s := m.shard(key)
+	s.RLock()
+	defer s.RUnlock()
+	v, ok := s.items[key]
+	return v, ok
+}
+
+func (m *shardedMap[V]) Store(key string, value V) {
+	s := m.shard(key)
+	s.Lock()
+	defer s.Unlock()
+	s.items[key] = value
+}
+
+func (m *shardedMap[V]) Delete(key string) {
+	s := m.shard(key)
+	s.Lock()
+	defer s.Unlock()
+	delete(s.items, key)
+}
+
+// Compute atomically replaces the value for key with the result of fn.
+// The entry is removed when fn returns keep == false.
+func (m *shardedMap[V]) Compute(key string, fn func(old V, ok bool) (value V, keep bool)) {
+	s := m.shard(key)
+	s.Lock()
+	defer s.Unlock()
+	old, ok := s.items[key]
+	value, keep := fn(old, ok)
+	if keep {
+		s.items[key] = value
+	} else {
+		delete(s.items, key)
+	}
+}
+
+// Values returns a snapshot of every value. Callers may block on the result
+// without holding any shard lock.
+func (m *shardedMap[V]) Values() []V {
+	var values []V
+	for _, s := range m.shards {
+		s.RLock()
+		for _, v := range s.items {
+			values = append(values, v)
+		}
+		s.RUnlock()
+	}
+	return values
+}
+
+func (m *shardedMap[V]) Len() int {
+	n := 0
+	for _, s := range m.shards {
+		s.RLock()
+		n += len(s.items)
+		s.RUnlock()
+	}
+	return n
+}
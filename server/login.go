@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/issy20/go-websocket/apierror"
+	"github.com/issy20/go-websocket/auth"
+	"github.com/issy20/go-websocket/models"
+	"github.com/issy20/go-websocket/repository"
+)
+
+// clientIP returns the address a request came from. X-Forwarded-For is only
+// trusted when the server runs behind a proxy.
+func (api *API) clientIP(r *http.Request) string {
+	if api.LoginConfig.TrustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginWait returns how long the username and address have to wait before
+// trying to log in again.
+func (api *API) loginWait(username string, ip string) time.Duration {
+	wait := api.UserThrottle.Wait(strings.ToLower(username))
+	if ipWait := api.IPThrottle.Wait(ip); ipWait > wait {
+		wait = ipWait
+	}
+	return wait
+}
+
+// loginFailed counts a failed login and records lockouts. userID is empty
+// for unknown usernames.
+func (api *API) loginFailed(username string, userID string, ip string) {
+	if api.UserThrottle.Failure(strings.ToLower(username)) {
+		api.audit(&models.AuditEvent{
+			Type:     models.AuditLoginLocked,
+			UserID:   userID,
+			Username: username,
+			IP:       ip,
+			Detail:   fmt.Sprintf("locked for %s after %d failed logins", api.LoginConfig.Lockout, api.LoginConfig.MaxFailures),
+		})
+	}
+	if api.IPThrottle.Failure(ip) {
+		api.audit(&models.AuditEvent{
+			Type:     models.AuditLoginIPLocked,
+			Username: username,
+			IP:       ip,
+			Detail:   fmt.Sprintf("locked for %s after %d failed logins", api.LoginConfig.Lockout, api.LoginConfig.IPMaxFailures),
+		})
+	}
+}
+
+func (api *API) loginSucceeded(username string) {
+	api.UserThrottle.Success(strings.ToLower(username))
+}
+
+func (api *API) audit(event *models.AuditEvent) {
+	event.CreatedAt = time.Now()
+	log.Printf("audit: %s user=%q username=%q ip=%s: %s", event.Type, event.UserID, event.Username, event.IP, event.Detail)
+	if err := api.AuditRepository.AddEvent(event); err != nil {
+		log.Println(err, "audit()")
+	}
+}
+
+// confirmPassword checks the password of a logged-in user before a
+// sensitive change. Wrong guesses count as failed logins, so that a stolen
+// access token cannot get around the login throttle.
+func (api *API) confirmPassword(w http.ResponseWriter, r *http.Request, user *repository.User, password string) bool {
+	ip := api.clientIP(r)
+	if wait := api.loginWait(user.Username, ip); wait > 0 {
+		writeRetryAfter(w, r, wait, http.StatusTooManyRequests)
+		return false
+	}
+	ok, err := auth.ComparePassword(password, user.Password)
+	if errors.Is(err, auth.ErrHashBusy) {
+		writeRetryAfter(w, r, time.Second, http.StatusServiceUnavailable)
+		return false
+	}
+	if !ok || err != nil {
+		api.loginFailed(user.Username, user.Id, ip)
+		apierror.Write(w, r, http.StatusForbidden, "wrong password")
+		return false
+	}
+	return true
+}
+
+func writeRetryAfter(w http.ResponseWriter, r *http.Request, wait time.Duration, code int) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+	apierror.Status(w, r, code)
+}
@@ -0,0 +1,29 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FileSender writes every message into a directory instead of sending it.
+type FileSender struct {
+	dir  string
+	from string
+}
+
+func NewFileSender(dir string, from string) (*FileSender, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	return &FileSender{dir: dir, from: from}, nil
+}
+
+func (s *FileSender) Send(ctx context.Context, to string, subject string, body string) error {
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405"), uuid.New().String())
+	return os.WriteFile(filepath.Join(s.dir, name), message(s.from, to, subject, body), 0o600)
+}
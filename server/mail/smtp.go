@@ -0,0 +1,55 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPSender sends mail through an SMTP server, using STARTTLS when the
+// server offers it.
+type SMTPSender struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func NewSMTPSender(addr string, username string, password string, from string) *SMTPSender {
+	s := &SMTPSender{addr: addr, from: from}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		s.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return s
+}
+
+func (s *SMTPSender) Send(ctx context.Context, to string, subject string, body string) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.addr, s.auth, s.from, []string{to}, message(s.from, to, subject, body))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// message formats a plain text RFC 5322 message. Header values have their
+// line breaks removed so that user input cannot add headers.
+func message(from string, to string, subject string, body string) []byte {
+	header := strings.NewReplacer("\r", "", "\n", "")
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", header.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", header.Replace(to))
+	fmt.Fprintf(&b, "Subject: %s\r\n", header.Replace(subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -2,52 +2,223 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/issy20/go-websocket/apierror"
 	"github.com/issy20/go-websocket/auth"
+	"github.com/issy20/go-websocket/broker"
 	"github.com/issy20/go-websocket/config"
+	"github.com/issy20/go-websocket/mail"
+	"github.com/issy20/go-websocket/models"
 	"github.com/issy20/go-websocket/repository"
+	"github.com/issy20/go-websocket/webhook"
 )
 
 var addr = flag.String("addr", ":8080", "http server address")
+var metricsAddr = flag.String("metrics-addr", "", "address serving expvar metrics at /debug/vars, kept off the public server; disabled when empty")
+var shutdownTimeout = flag.Duration("shutdown-timeout", 15*time.Second, "time allowed for draining connections on shutdown")
 var ctx = context.Background()
 
 func main() {
 	flag.Parse()
-	config.CreateRedisClient()
-	db, err := config.NewDB()
+	brokerConfig, err := config.NewBrokerConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var messageBroker models.Broker
+	switch brokerConfig.Kind {
+	case config.MemoryBroker:
+		messageBroker = broker.NewMemoryBroker()
+	case config.StreamsBroker:
+		config.CreateRedisClient()
+		messageBroker = broker.NewStreamBroker(config.Redis, brokerConfig.NodeID, brokerConfig.StreamMaxLen)
+	default:
+		config.CreateRedisClient()
+		messageBroker = broker.NewRedisBroker(config.Redis)
+	}
+
+	jwtConfig, err := config.NewJWTConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	keySet, err := auth.LoadKeySet(jwtConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.DB.Close()
-	defer func() {
-		err := db.DB.Close()
+	auth.UseKeySet(keySet)
+	auth.UseJWTConfig(jwtConfig)
+	authConfig := config.NewAuthConfig()
+	auth.UseAuthConfig(authConfig)
+	passwordConfig, err := config.NewPasswordConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	auth.UsePasswordConfig(passwordConfig)
+	loginConfig, err := config.NewLoginConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	auth.UseHashPool(loginConfig.HashConcurrency, loginConfig.HashWait)
+
+	mailConfig, err := config.NewMailConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var mailSender models.MailSender
+	switch mailConfig.Kind {
+	case config.SMTPMailer:
+		mailSender = mail.NewSMTPSender(mailConfig.SMTPAddr, mailConfig.SMTPUsername, mailConfig.SMTPPassword, mailConfig.From)
+	default:
+		mailSender, err = mail.NewFileSender(mailConfig.Dir, mailConfig.From)
 		if err != nil {
 			log.Fatal(err)
 		}
-	}()
+	}
+
+	db, err := config.NewDB()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	clientConfig, err := config.NewClientConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	userRepository := &repository.UserRepository{Db: db.DB}
+	auth.UseUserRepository(userRepository)
+	authSessionRepository := &repository.AuthSessionRepository{Db: db.DB}
+	auth.UseSessionRepository(authSessionRepository)
+	apiKeyRepository := &repository.APIKeyRepository{Db: db.DB}
+	auth.UseAPIKeyRepository(apiKeyRepository)
 
-	hub := NewHub(&repository.RoomRepository{Db: db.DB}, userRepository)
+	webhookConfig, err := config.NewWebhookConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	webhookRepository := &repository.WebhookRepository{Db: db.DB}
+	webhooks := webhook.NewDispatcher(webhookRepository, webhookConfig)
+	go webhooks.Run()
+
+	hub := NewHub(&repository.RoomRepository{Db: db.DB}, userRepository, &repository.MessageRepository{Db: db.DB}, clientConfig, messageBroker)
+	hub.Events = webhooks
 	go hub.RunLoop()
 
-	api := &API{UserRepository: userRepository}
+	api := &API{
+		UserRepository:            userRepository,
+		AuthSessionRepository:     authSessionRepository,
+		UserIdentityRepository:    &repository.UserIdentityRepository{Db: db.DB},
+		PasswordResetRepository:   &repository.PasswordResetRepository{Db: db.DB},
+		AuditRepository:           &repository.AuditRepository{Db: db.DB},
+		APIKeyRepository:          apiKeyRepository,
+		TOTPRepository:            &repository.TOTPRepository{Db: db.DB},
+		WebhookRepository:         webhookRepository,
+		Webhooks:                  webhooks,
+		IncomingWebhookRepository: &repository.IncomingWebhookRepository{Db: db.DB},
+		IncomingWebhookLimiter:    webhook.NewRateLimiter(webhookConfig.IncomingRate, time.Minute, webhookConfig.IncomingBurst),
+		MailSender:                mailSender,
+		AuthConfig:                authConfig,
+		PasswordConfig:            passwordConfig,
+		LoginConfig:               loginConfig,
+		UserThrottle:              auth.NewThrottle(0, loginConfig.MaxFailures, loginConfig.Backoff, loginConfig.MaxBackoff, loginConfig.Lockout),
+		IPThrottle:                auth.NewThrottle(loginConfig.IPMaxFailures/2, loginConfig.IPMaxFailures, loginConfig.Backoff, loginConfig.MaxBackoff, loginConfig.Lockout),
+		Hub:                       hub,
+	}
+
+	oidcConfig, err := config.NewOIDCConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if oidcConfig.Enabled() {
+		api.OIDCProvider, err = auth.NewOIDCProvider(ctx, oidcConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	originConfig, err := config.NewOriginConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if originConfig.AllowAllOrigins {
+		log.Println("ALLOW_ALL_ORIGINS is set, accepting requests from any origin")
+	}
+	origins := NewOrigins(originConfig)
+	upgrader.CheckOrigin = origins.CheckOrigin
+
+	// Routes go on their own mux, since importing expvar registers
+	// /debug/vars on http.DefaultServeMux.
+	mux := http.NewServeMux()
 
-	http.HandleFunc("/ws", auth.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/ws", auth.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		ServeWs(hub, w, r)
 	}))
 
-	http.HandleFunc("/api/login", api.HandleLogin)
-	http.HandleFunc("/api/create", api.HandleAddUser)
+	for _, route := range api.apiRoutes(origins) {
+		mux.HandleFunc(route.pattern, route.handler)
+	}
+	mux.HandleFunc("/.well-known/jwks.json", auth.HandleJWKS)
+	mux.HandleFunc("/.well-known/openapi.json", origins.CORS(HandleDocs(OpenAPIDocument())))
+	mux.HandleFunc("/.well-known/asyncapi.json", origins.CORS(HandleDocs(AsyncAPIDocument())))
 
 	port := "80"
-	log.Printf("Listening on port %s", port)
-	if err := http.ListenAndServe(fmt.Sprintf(":%v", port), nil); err != nil {
-		log.Panicln("Serve Error:", err)
+	server := &http.Server{Addr: fmt.Sprintf(":%v", port), Handler: apierror.WithRequestID(mux)}
+
+	signalCtx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	go func() {
+		log.Printf("Listening on port %s", port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Panicln("Serve Error:", err)
+		}
+	}()
+
+	var metricsServer *http.Server
+	if *metricsAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/debug/vars", expvar.Handler())
+		metricsServer = &http.Server{Addr: *metricsAddr, Handler: metricsMux}
+		go func() {
+			log.Printf("Serving metrics on %s", *metricsAddr)
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Println("metrics serve error:", err)
+			}
+		}()
+	}
+
+	<-signalCtx.Done()
+	log.Println("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, *shutdownTimeout)
+	defer cancel()
+
+	// Stop accepting upgrades first so no client registers while draining.
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("http shutdown:", err)
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			log.Println("metrics shutdown:", err)
+		}
+	}
+	if err := hub.Shutdown(shutdownCtx); err != nil {
+		log.Println("hub shutdown:", err)
+	}
+	if err := webhooks.Shutdown(shutdownCtx); err != nil {
+		log.Println("webhook shutdown:", err)
+	}
+	if err := messageBroker.Close(); err != nil {
+		log.Println("broker close:", err)
+	}
+	if err := db.DB.Close(); err != nil {
+		log.Println("db close:", err)
 	}
-	log.Fatal(http.ListenAndServe(*addr, nil))
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 
+	"github.com/google/uuid"
 	"github.com/issy20/go-websocket/models"
 	jsoniter "github.com/json-iterator/go"
 )
@@ -14,12 +15,58 @@ const UserJoinedAction = "user-join"
 const UserLeftAction = "user-left"
 const JoinRoomPrivateAction = "join-room-private"
 const RoomJoinedAction = "room-joined"
+const SessionAction = "session"
+const ResumeAction = "resume"
+const ResumedAction = "resumed"
+const ResyncAction = "resync"
+
+const ErrorAction = "error"
+const GuestTokenAction = "guest-token"
+const SetGuestPolicyAction = "set-guest-policy"
+const GuestPolicyAction = "guest-policy"
+
+// UserUpdatedAction tells clients that Sender changed their profile.
+const UserUpdatedAction = "user-updated"
+
+// SessionRevokedAction only travels between nodes; it closes the connections
+// of a revoked login session.
+const SessionRevokedAction = "session-revoked"
 
 type Message struct {
+	// ID is assigned by the server to chat messages when they are stored.
+	ID      string       `json:"id,omitempty"`
 	Action  string       `json:"action"`
 	Message string       `json:"message"`
 	Target  *Room        `json:"target"`
 	Sender  models.IUser `json:"sender"`
+	// Seq is the room sequence number of a message delivered to a room.
+	Seq uint64 `json:"seq,omitempty"`
+	// Cursors maps room IDs to the last Seq a resuming client has seen.
+	Cursors map[string]uint64 `json:"cursors,omitempty"`
+	// Error details an ErrorAction message.
+	Error *MessageError `json:"error,omitempty"`
+}
+
+// Sender is how clients are encoded as the sender of a message.
+type Sender struct {
+	ID    uuid.UUID `json:"id"`
+	Name  string    `json:"name"`
+	Guest bool      `json:"guest,omitempty"`
+	Bot   bool      `json:"bot,omitempty"`
+}
+
+// Error codes of an ErrorAction message.
+const (
+	ErrorForbidden = "forbidden"
+	ErrorNotFound  = "not_found"
+	ErrorInvalid   = "invalid"
+	ErrorInternal  = "internal"
+)
+
+// MessageError names the action a client was refused and why.
+type MessageError struct {
+	Action string `json:"action"`
+	Code   string `json:"code"`
 }
 
 func (message *Message) Encode() []byte {
@@ -32,12 +79,10 @@ func (message *Message) Encode() []byte {
 	return j
 }
 
-func (message *Message) UnMarshalJSON(data []byte) error {
-	log.Print(data)
-
+func (message *Message) UnmarshalJSON(data []byte) error {
 	type Alias Message
 	msg := &struct {
-		Sender Client `json:"sender"`
+		Sender *Client `json:"sender"`
 		*Alias
 	}{
 		Alias: (*Alias)(message),
@@ -47,6 +92,8 @@ func (message *Message) UnMarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, &msg); err != nil {
 		return err
 	}
-	message.Sender = &msg.Sender
+	if msg.Sender != nil {
+		message.Sender = msg.Sender
+	}
 	return nil
 }
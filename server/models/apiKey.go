@@ -0,0 +1,36 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrAPIKeyRevoked  = errors.New("api key revoked")
+	ErrAPIKeyExpired  = errors.New("api key expired")
+)
+
+// APIKey lets a service account authenticate without logging in. The key
+// itself is only stored as a hash.
+type APIKey struct {
+	ID     string   `json:"id"`
+	UserID string   `json:"service_account_id"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// UserName is the name of the service account.
+	UserName  string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+type APIKeyRepository interface {
+	AddAPIKey(key *APIKey, keyHash string) error
+	// FindAPIKeyByHash returns the key with the given hash, or
+	// ErrAPIKeyNotFound, ErrAPIKeyRevoked or ErrAPIKeyExpired.
+	FindAPIKeyByHash(keyHash string) (*APIKey, error)
+	FindAPIKeysByUserId(userID string) ([]*APIKey, error)
+	RevokeAPIKey(id string, userID string) error
+	RevokeAPIKeysByUserId(userID string) error
+}
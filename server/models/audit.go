@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Audit event types.
+const (
+	AuditLoginLocked   = "login.locked"
+	AuditLoginIPLocked = "login.ip_locked"
+	AuditTOTPEnabled   = "totp.enabled"
+	AuditTOTPDisabled  = "totp.disabled"
+	AuditTOTPReset     = "totp.reset"
+	AuditRecoveryCode  = "totp.recovery_code_used"
+)
+
+// AuditEvent records a security relevant event. UserID is empty when the
+// event is not tied to a known user.
+type AuditEvent struct {
+	Type      string
+	UserID    string
+	Username  string
+	IP        string
+	Detail    string
+	CreatedAt time.Time
+}
+
+type AuditRepository interface {
+	AddEvent(event *AuditEvent) error
+}
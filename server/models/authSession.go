@@ -0,0 +1,30 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenExpired  = errors.New("refresh token expired")
+	ErrRefreshTokenReused   = errors.New("refresh token reused")
+	ErrSessionRevoked       = errors.New("session revoked")
+)
+
+// AuthSessionRepository stores login sessions and their rotating refresh
+// tokens. Tokens are only ever stored as hashes.
+type AuthSessionRepository interface {
+	CreateSession(id string, userID string) error
+	RevokeSession(id string) error
+	// RevokeUserSessions revokes every live session of the user except
+	// exceptID and returns the IDs it revoked.
+	RevokeUserSessions(userID string, exceptID string) ([]string, error)
+	IsSessionRevoked(id string) (bool, error)
+	AddRefreshToken(tokenHash string, sessionID string, expiresAt time.Time) error
+	// UseRefreshToken marks the token as used and returns its session. A
+	// token that was already used returns ErrRefreshTokenReused together
+	// with its session so that the caller can revoke it.
+	UseRefreshToken(tokenHash string) (sessionID string, userID string, err error)
+	FindSessionByRefreshToken(tokenHash string) (sessionID string, userID string, err error)
+}
@@ -0,0 +1,42 @@
+package models
+
+// User kinds.
+const (
+	UserKindUser    = "user"
+	UserKindService = "service"
+)
+
+// API key scopes.
+const (
+	// ScopeChatRead lets a key connect and join rooms.
+	ScopeChatRead = "chat:read"
+	// ScopeChatWrite lets a key send messages.
+	ScopeChatWrite = "chat:write"
+)
+
+// BotUser is implemented by users that may be service accounts acting
+// through an API key.
+type BotUser interface {
+	IsBot() bool
+	HasScope(scope string) bool
+}
+
+func IsBot(user IUser) bool {
+	bot, ok := user.(BotUser)
+	return ok && bot.IsBot()
+}
+
+// HasScope reports whether user may act within scope. Only API keys are
+// scoped, other users may do everything.
+func HasScope(user IUser, scope string) bool {
+	bot, ok := user.(BotUser)
+	return !ok || bot.HasScope(scope)
+}
+
+func ValidScope(scope string) bool {
+	switch scope {
+	case ScopeChatRead, ScopeChatWrite:
+		return true
+	}
+	return false
+}
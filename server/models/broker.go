@@ -0,0 +1,16 @@
+package models
+
+import "context"
+
+// Subscription delivers the payloads published to one channel. Messages is
+// closed once the subscription ends.
+type Subscription interface {
+	Messages() <-chan []byte
+}
+
+type Broker interface {
+	Publish(ctx context.Context, channel string, message []byte) error
+	Subscribe(ctx context.Context, channel string) (Subscription, error)
+	Unsubscribe(sub Subscription) error
+	Close() error
+}
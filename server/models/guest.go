@@ -0,0 +1,26 @@
+package models
+
+// Guest policies of a room.
+const (
+	GuestsAllowed  = "allowed"
+	GuestsReadOnly = "read-only"
+	GuestsDenied   = "denied"
+)
+
+// GuestUser is implemented by users that may have connected without an account.
+type GuestUser interface {
+	IsGuest() bool
+}
+
+func IsGuest(user IUser) bool {
+	guest, ok := user.(GuestUser)
+	return ok && guest.IsGuest()
+}
+
+func ValidGuestPolicy(policy string) bool {
+	switch policy {
+	case GuestsAllowed, GuestsReadOnly, GuestsDenied:
+		return true
+	}
+	return false
+}
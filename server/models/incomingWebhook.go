@@ -0,0 +1,32 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrIncomingWebhookNotFound = errors.New("incoming webhook not found")
+	ErrIncomingWebhookRevoked  = errors.New("incoming webhook revoked")
+)
+
+// IncomingWebhook posts what is sent to its secret URL into a room, as a
+// message from an integration called Name. The token in the URL is only
+// stored as a hash.
+type IncomingWebhook struct {
+	ID        string     `json:"id"`
+	RoomID    string     `json:"room_id"`
+	Name      string     `json:"name"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+type IncomingWebhookRepository interface {
+	AddIncomingWebhook(hook *IncomingWebhook, tokenHash string) error
+	// FindIncomingWebhookByHash returns the webhook with the given token
+	// hash, or ErrIncomingWebhookNotFound or ErrIncomingWebhookRevoked.
+	FindIncomingWebhookByHash(tokenHash string) (*IncomingWebhook, error)
+	FindIncomingWebhooksByRoomId(roomID string) ([]*IncomingWebhook, error)
+	RevokeIncomingWebhook(id string, roomID string) error
+}
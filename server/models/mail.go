@@ -0,0 +1,8 @@
+package models
+
+import "context"
+
+// MailSender delivers plain text mail.
+type MailSender interface {
+	Send(ctx context.Context, to string, subject string, body string) error
+}
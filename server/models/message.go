@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// ChatMessage is a message sent to a room, as it is stored.
+type ChatMessage struct {
+	ID        string
+	RoomID    string
+	SenderID  string
+	Body      string
+	CreatedAt time.Time
+}
+
+type MessageRepository interface {
+	AddMessage(message *ChatMessage) error
+}
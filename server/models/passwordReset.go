@@ -0,0 +1,19 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrResetTokenNotFound = errors.New("reset token not found")
+	ErrResetTokenExpired  = errors.New("reset token expired")
+	ErrResetTokenUsed     = errors.New("reset token already used")
+)
+
+// PasswordResetRepository stores single-use password reset tokens as hashes.
+type PasswordResetRepository interface {
+	AddResetToken(tokenHash string, userID string, expiresAt time.Time) error
+	// UseResetToken marks the token as used and returns its user.
+	UseResetToken(tokenHash string) (userID string, err error)
+}
@@ -0,0 +1,16 @@
+package models
+
+// Who may create rooms or start direct messages.
+const (
+	AllowAnyone     = "anyone"
+	AllowRegistered = "registered"
+	AllowNobody     = "nobody"
+)
+
+func ValidAllow(allow string) bool {
+	switch allow {
+	case AllowAnyone, AllowRegistered, AllowNobody:
+		return true
+	}
+	return false
+}
@@ -4,9 +4,13 @@ type Room interface {
 	GetId() string
 	GetName() string
 	GetPrivate() bool
+	GetOwnerId() string
+	GetGuestPolicy() string
 }
 
 type RoomRepository interface {
-	AddRoom(room Room)
+	AddRoom(room Room) error
 	FindRoomByName(name string) Room
+	FindRoomById(id string) Room
+	SetGuestPolicy(roomID string, policy string) error
 }
@@ -0,0 +1,27 @@
+package models
+
+// TOTP is a user's authenticator enrolment. It only protects logins once
+// Enabled, i.e. after the user proved the authenticator works.
+type TOTP struct {
+	UserID  string
+	Secret  string
+	Enabled bool
+	// LastStep is the time step of the last accepted code, so that a code
+	// cannot be used twice.
+	LastStep int64
+}
+
+type TOTPRepository interface {
+	// SetSecret starts a new, not yet enabled enrolment.
+	SetSecret(userID string, secret string) error
+	// FindTOTP returns nil when the user has not enrolled.
+	FindTOTP(userID string) (*TOTP, error)
+	// EnableTOTP enables the enrolment and replaces the recovery codes.
+	EnableTOTP(userID string, recoveryCodeHashes []string) error
+	// UseStep records step as used and reports false if it, or a later
+	// step, was used before.
+	UseStep(userID string, step int64) (bool, error)
+	// UseRecoveryCode spends a recovery code and reports whether it was valid.
+	UseRecoveryCode(userID string, codeHash string) (bool, error)
+	DeleteTOTP(userID string) error
+}
@@ -1,13 +1,21 @@
 package models
 
+import "errors"
+
+var ErrDuplicateUsername = errors.New("username already taken")
+
 type IUser interface {
 	GetId() string
 	GetName() string
 }
 
 type UserRepository interface {
-	AddUser(id string, name string, username string, password string) error
+	AddUser(id string, name string, username string, email string, password string) error
 	RemoveUser(user IUser)
 	FindUserById(ID string) IUser
 	GetAllUsers() []IUser
+	// FindDirectMessagePolicy returns who may start direct messages with the
+	// user, or "" when the user has not chosen.
+	FindDirectMessagePolicy(id string) (string, error)
+	SetDirectMessagePolicy(id string, allow string) error
 }
@@ -0,0 +1,16 @@
+package models
+
+// UserIdentityRepository maps subjects of external identity providers to
+// local users.
+type UserIdentityRepository interface {
+	// FindUserIdByIdentity returns "" when the identity is not linked yet.
+	FindUserIdByIdentity(issuer string, subject string) (string, error)
+	AddIdentity(issuer string, subject string, userID string) error
+	// AddUserWithIdentity creates a user and links the identity to it in one
+	// transaction. It returns ErrDuplicateUsername when the username is taken.
+	AddUserWithIdentity(issuer string, subject string, id string, name string, username string, email string, password string) error
+	// HasIdentity reports whether the user logs in through an identity
+	// provider.
+	HasIdentity(userID string) (bool, error)
+	RemoveIdentities(userID string) error
+}
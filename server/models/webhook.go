@@ -0,0 +1,97 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// Webhook event types.
+const (
+	EventMessageCreated = "message.created"
+	// EventUserJoined and EventUserLeft follow the user-join and user-left
+	// actions, i.e. a user's connections coming and going.
+	EventUserJoined  = "user.joined"
+	EventUserLeft    = "user.left"
+	EventRoomCreated = "room.created"
+)
+
+func ValidEvent(event string) bool {
+	switch event {
+	case EventMessageCreated, EventUserJoined, EventUserLeft, EventRoomCreated:
+		return true
+	}
+	return false
+}
+
+// Webhook is an endpoint an admin registered for events. Deliveries are
+// signed with Secret.
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"-"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (w *Webhook) Subscribes(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery states. Dead deliveries failed every attempt; they are kept as
+// dead letters until redelivered.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// WebhookDelivery is one event sent to one webhook, and the log of its
+// attempts so far.
+type WebhookDelivery struct {
+	ID        string          `json:"id"`
+	WebhookID string          `json:"webhook_id"`
+	Event     string          `json:"event"`
+	Payload   json.RawMessage `json:"payload"`
+	State     string          `json:"state"`
+	Attempts  int             `json:"attempts"`
+	// LastStatus is the HTTP status of the last attempt, zero when the
+	// request failed before a response.
+	LastStatus int       `json:"last_status,omitempty"`
+	LastError  string    `json:"last_error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type WebhookRepository interface {
+	AddWebhook(hook *Webhook) error
+	FindWebhooks() ([]*Webhook, error)
+	// DeleteWebhook returns ErrWebhookNotFound for unknown IDs.
+	DeleteWebhook(id string) error
+	AddDelivery(delivery *WebhookDelivery) error
+	UpdateDelivery(delivery *WebhookDelivery) error
+	// FindDelivery returns nil for unknown IDs.
+	FindDelivery(id string) (*WebhookDelivery, error)
+	// FindDeliveries returns the newest deliveries first. Empty webhookID
+	// and state match every delivery.
+	FindDeliveries(webhookID string, state string, limit int) ([]*WebhookDelivery, error)
+	// FindPendingDeliveries returns pending deliveries last updated before
+	// updatedBefore, least recently updated first.
+	FindPendingDeliveries(updatedBefore time.Time, limit int) ([]*WebhookDelivery, error)
+	// ClaimDelivery sets the UpdatedAt of a pending delivery to now unless
+	// it changed since it was read, and reports whether it did.
+	ClaimDelivery(delivery *WebhookDelivery, now time.Time) (bool, error)
+}
+
+// EventEmitter hands chat events to webhooks. data is encoded as JSON.
+type EventEmitter interface {
+	Emit(event string, data interface{})
+}
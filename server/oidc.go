@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/issy20/go-websocket/apierror"
+	"github.com/issy20/go-websocket/auth"
+	"github.com/issy20/go-websocket/models"
+	"github.com/issy20/go-websocket/repository"
+)
+
+const oidcStateCookie = "oidc_state"
+
+// HandleOIDCLogin redirects to the identity provider. ?cookie=true asks for
+// the access token in an HttpOnly cookie after the callback.
+func (api *API) HandleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	state, err := auth.NewOIDCState(r.URL.Query().Get("cookie") == "true")
+	if err != nil {
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	value, err := api.OIDCProvider.EncodeState(state)
+	if err != nil {
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/api/oidc",
+		Expires:  time.Unix(state.ExpiresAt, 0),
+		HttpOnly: true,
+		Secure:   api.AuthConfig.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, api.OIDCProvider.AuthCodeURL(state), http.StatusFound)
+}
+
+// HandleOIDCCallback finishes the login, links or provisions the local user
+// and answers like HandleLogin.
+func (api *API) HandleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/api/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   api.AuthConfig.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		log.Printf("oidc callback: %s: %s", errCode, query.Get("error_description"))
+		apierror.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, auth.ErrOIDCState.Error())
+		return
+	}
+	state, err := api.OIDCProvider.DecodeState(cookie.Value, query.Get("state"))
+	if err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rawIDToken, err := api.OIDCProvider.Exchange(r.Context(), query.Get("code"), state.Verifier)
+	if err != nil {
+		log.Println(err, "HandleOIDCCallback()")
+		apierror.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+	identity, err := api.OIDCProvider.VerifyIDToken(r.Context(), rawIDToken, state.Nonce)
+	if err != nil {
+		log.Println(err, "HandleOIDCCallback()")
+		apierror.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := api.findOrProvisionUser(identity)
+	if err != nil {
+		log.Println(err, "HandleOIDCCallback()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	// The identity provider stands in for the password only; users with
+	// 2FA still finish at /api/login/mfa.
+	totp, err := api.TOTPRepository.FindTOTP(user.GetId())
+	if err != nil {
+		log.Println(err, "HandleOIDCCallback()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	if totp != nil && totp.Enabled {
+		api.writeMFAChallenge(w, r, user)
+		return
+	}
+
+	sessionID := uuid.New().String()
+	if err := api.AuthSessionRepository.CreateSession(sessionID, user.GetId()); err != nil {
+		log.Println(err, "HandleOIDCCallback()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	api.writeTokens(w, r, user, sessionID, state.Cookie)
+}
+
+// findOrProvisionUser returns the user linked to identity, creating one on
+// first login. Provisioned users get an unguessable password and cannot
+// reset it, so they can only log in through the identity provider.
+func (api *API) findOrProvisionUser(identity *auth.OIDCIdentity) (models.IUser, error) {
+	userID, err := api.UserIdentityRepository.FindUserIdByIdentity(identity.Issuer, identity.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if userID != "" {
+		if user := api.UserRepository.FindUserById(userID); user != nil {
+			return user, nil
+		}
+	}
+
+	name := identity.Name
+	if name == "" {
+		name = identity.PreferredUsername
+	}
+	if name == "" {
+		name = identity.Email
+	}
+	if name == "" {
+		name = identity.Subject
+	}
+	secret, _, err := auth.NewRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	password, err := auth.GeneratePassword(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &repository.User{Id: uuid.New().String(), Name: name, Username: identity.Username(), Email: identity.Email}
+	err = api.UserIdentityRepository.AddUserWithIdentity(identity.Issuer, identity.Subject, user.Id, user.Name, user.Username, user.Email, password)
+	if errors.Is(err, models.ErrDuplicateUsername) {
+		return api.linkProvisionedUser(identity)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// linkProvisionedUser handles a provisioned username that already exists:
+// either a concurrent first login linked it meanwhile, or it was left
+// without an identity by a failed provisioning. Usernames with a colon can
+// only be created here, so an unlinked one is linked to identity.
+func (api *API) linkProvisionedUser(identity *auth.OIDCIdentity) (models.IUser, error) {
+	userID, err := api.UserIdentityRepository.FindUserIdByIdentity(identity.Issuer, identity.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if userID != "" {
+		if user := api.UserRepository.FindUserById(userID); user != nil {
+			return user, nil
+		}
+	}
+
+	user := api.UserRepository.FindUserByUsername(identity.Username())
+	if user == nil {
+		return nil, fmt.Errorf("oidc user %s vanished during provisioning", identity.Subject)
+	}
+	// A user that is already linked belongs to another identity.
+	linked, err := api.UserIdentityRepository.HasIdentity(user.Id)
+	if err != nil {
+		return nil, err
+	}
+	if linked {
+		return nil, fmt.Errorf("oidc username of %s is linked to another identity", identity.Subject)
+	}
+	if err := api.UserIdentityRepository.AddIdentity(identity.Issuer, identity.Subject, user.Id); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/issy20/go-websocket/apierror"
+	"github.com/issy20/go-websocket/config"
+)
+
+const corsMaxAge = "600"
+
+// Origins checks the Origin header of WebSocket upgrades and API requests
+// against the configured allow-list. Requests without an Origin header do
+// not come from a browser page and are let through.
+type Origins struct {
+	allowAll bool
+	exact    map[string]bool
+	// wildcards holds "scheme://" and ".domain[:port]" pairs of
+	// "scheme://*.domain[:port]" entries.
+	wildcards [][2]string
+}
+
+func NewOrigins(c *config.OriginConfig) *Origins {
+	o := &Origins{allowAll: c.AllowAllOrigins, exact: make(map[string]bool)}
+	for _, origin := range c.AllowedOrigins {
+		if scheme, host, ok := strings.Cut(origin, "://*."); ok {
+			o.wildcards = append(o.wildcards, [2]string{scheme + "://", "." + host})
+		} else {
+			o.exact[origin] = true
+		}
+	}
+	return o
+}
+
+func (o *Origins) Allowed(origin string) bool {
+	if o.allowAll {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if o.exact[origin] {
+		return true
+	}
+	for _, w := range o.wildcards {
+		if host := strings.TrimPrefix(origin, w[0]); host != origin && strings.HasSuffix(host, w[1]) && len(host) > len(w[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckOrigin is the upgrader's origin check.
+func (o *Origins) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || o.Allowed(origin) {
+		return true
+	}
+	log.Printf("rejected websocket upgrade from origin %s (%s)", origin, r.RemoteAddr)
+	return false
+}
+
+// CORS answers preflight requests and adds the CORS headers for allowed
+// origins. Requests from other origins are rejected, which also keeps other
+// sites from using the session cookie.
+func (o *Origins) CORS(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			f(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !o.Allowed(origin) {
+			log.Printf("rejected %s %s from origin %s (%s)", r.Method, r.URL.Path, origin, r.RemoteAddr)
+			apierror.Status(w, r, http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		f(w, r)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/issy20/go-websocket/apierror"
+	"github.com/issy20/go-websocket/auth"
+	"github.com/issy20/go-websocket/models"
+	"github.com/issy20/go-websocket/repository"
+)
+
+type ChangePasswordInput struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type ResetPasswordInput struct {
+	Email string `json:"email"`
+}
+
+type ConfirmResetInput struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// HandleChangePassword sets a new password for the authenticated user after
+// checking the old one, throttled like logins. The user's other sessions are
+// logged out.
+func (api *API) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	user, ok := r.Context().Value(auth.UserContextKey).(models.IUser)
+	if !ok || models.IsGuest(user) {
+		apierror.Status(w, r, http.StatusForbidden)
+		return
+	}
+	var input ChangePasswordInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dbUser := api.UserRepository.FindUserWithPasswordById(user.GetId())
+	if dbUser == nil {
+		apierror.Status(w, r, http.StatusForbidden)
+		return
+	}
+	if !api.confirmPassword(w, r, dbUser, input.OldPassword) {
+		return
+	}
+	if err := auth.CheckPassword(input.NewPassword, dbUser.Username); err != nil {
+		apierror.WriteField(w, r, "new_password", err.Error())
+		return
+	}
+	if err := api.setPassword(dbUser, input.NewPassword); err != nil {
+		log.Println(err, "HandleChangePassword()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	sessionID := ""
+	if sessionUser, ok := user.(interface{ GetSessionId() string }); ok {
+		sessionID = sessionUser.GetSessionId()
+	}
+	api.revokeUserSessions(dbUser, sessionID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleResetPassword mails a reset link to the account with the given
+// email. It answers the same whether or not the account exists so that it
+// cannot be used to find out who has an account.
+func (api *API) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	var input ResetPasswordInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.Email != "" {
+		if user := api.UserRepository.FindUserByEmail(input.Email); user != nil {
+			if err := api.resetPassword(r.Context(), user); err != nil {
+				log.Println(err, "HandleResetPassword()")
+			}
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// resetPassword mails a reset link unless the user logs in through an
+// identity provider, whose password is not theirs to set.
+func (api *API) resetPassword(ctx context.Context, user *repository.User) error {
+	linked, err := api.UserIdentityRepository.HasIdentity(user.Id)
+	if err != nil || linked {
+		return err
+	}
+	return api.sendResetMail(ctx, user)
+}
+
+func (api *API) sendResetMail(ctx context.Context, user *repository.User) error {
+	token, hash, err := auth.NewRefreshToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(api.PasswordConfig.ResetTokenTTL)
+	if err := api.PasswordResetRepository.AddResetToken(hash, user.Id, expiresAt); err != nil {
+		return err
+	}
+
+	link := token
+	if api.PasswordConfig.ResetURL != "" {
+		link = api.PasswordConfig.ResetURL + "?token=" + url.QueryEscape(token)
+	}
+	body := fmt.Sprintf("Hello %s,\n\nUse this link to choose a new password:\n\n%s\n\nIt expires at %s. If you did not ask for it, ignore this mail.\n",
+		user.Name, link, expiresAt.Format(time.RFC1123))
+	return api.MailSender.Send(ctx, user.Email, "Reset your password", body)
+}
+
+// HandleConfirmResetPassword sets a new password with a reset token and
+// logs out every session of the user.
+func (api *API) HandleConfirmResetPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	var input ConfirmResetInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID, err := api.PasswordResetRepository.UseResetToken(auth.HashRefreshToken(input.Token))
+	if errors.Is(err, models.ErrResetTokenNotFound) || errors.Is(err, models.ErrResetTokenUsed) || errors.Is(err, models.ErrResetTokenExpired) {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Println(err, "HandleConfirmResetPassword()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	user := api.UserRepository.FindUserWithPasswordById(userID)
+	if user == nil {
+		apierror.Write(w, r, http.StatusBadRequest, models.ErrResetTokenNotFound.Error())
+		return
+	}
+	// Linked users cannot set a password, even with a token issued earlier.
+	linked, err := api.UserIdentityRepository.HasIdentity(user.Id)
+	if err != nil {
+		log.Println(err, "HandleConfirmResetPassword()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	if linked {
+		apierror.Write(w, r, http.StatusBadRequest, models.ErrResetTokenNotFound.Error())
+		return
+	}
+	if err := auth.CheckPassword(input.NewPassword, user.Username); err != nil {
+		apierror.WriteField(w, r, "new_password", err.Error())
+		return
+	}
+	if err := api.setPassword(user, input.NewPassword); err != nil {
+		log.Println(err, "HandleConfirmResetPassword()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	api.revokeUserSessions(user, "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) setPassword(user *repository.User, password string) error {
+	hashedPassword, err := auth.GeneratePassword(password)
+	if err != nil {
+		return err
+	}
+	return api.UserRepository.UpdatePassword(user.Id, hashedPassword)
+}
+
+// revokeUserSessions logs the user out everywhere except exceptID.
+func (api *API) revokeUserSessions(user models.IUser, exceptID string) {
+	sessionIDs, err := api.AuthSessionRepository.RevokeUserSessions(user.GetId(), exceptID)
+	if err != nil {
+		log.Println(err, "revokeUserSessions()")
+		return
+	}
+	for _, sessionID := range sessionIDs {
+		api.Hub.PublishSessionRevoked(user, sessionID)
+	}
+}
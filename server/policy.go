@@ -0,0 +1,187 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/issy20/go-websocket/config"
+	"github.com/issy20/go-websocket/models"
+)
+
+// PolicyError is why a client may not perform an action. Code is one of
+// the Error* codes sent in the error frame.
+type PolicyError struct {
+	Code   string
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Reason
+}
+
+func forbidden(reason string) *PolicyError {
+	return &PolicyError{Code: ErrorForbidden, Reason: reason}
+}
+
+func notFound(reason string) *PolicyError {
+	return &PolicyError{Code: ErrorNotFound, Reason: reason}
+}
+
+// Policy decides which WebSocket actions a client may perform. Every action
+// a client sends is checked here before it is handled.
+type Policy struct {
+	hub    *Hub
+	config *config.ClientConfig
+}
+
+func NewPolicy(hub *Hub, clientConfig *config.ClientConfig) *Policy {
+	return &Policy{hub: hub, config: clientConfig}
+}
+
+// Authorize returns nil when c may perform message.
+func (p *Policy) Authorize(c *Client, message *Message) *PolicyError {
+	switch message.Action {
+	case SendMessageAction:
+		return p.authorizeSend(c, message)
+	case JoinRoomAction:
+		return p.authorizeJoin(c, message.Message)
+	case JoinRoomPrivateAction:
+		return p.authorizeDirectMessage(c, message.Message)
+	case SetGuestPolicyAction:
+		return p.authorizeRoomOwner(c, message)
+	}
+	return nil
+}
+
+func (p *Policy) authorizeSend(c *Client, message *Message) *PolicyError {
+	if message.Target == nil {
+		return notFound("room not found")
+	}
+	room := p.hub.FindRoomByID(message.Target.GetId())
+	if room == nil {
+		return notFound("room not found")
+	}
+	if !c.IsInRoom(room) {
+		return forbidden("join the room before sending messages")
+	}
+	return p.authorizeWrite(c.user, room)
+}
+
+// AuthorizePost returns nil when user may post to room other than over its
+// WebSocket, as through the REST API. Private rooms only take posts from the
+// two users of the conversation, whose IDs make up its name. Public rooms
+// take posts from their owner and from users, bots included, who joined them
+// on a connection to this node. Membership is not shared between nodes, so
+// behind a Redis or Streams broker a member must post through the node it is
+// connected to.
+func (p *Policy) AuthorizePost(user models.IUser, room *Room) *PolicyError {
+	if room.Private {
+		if !strings.HasPrefix(room.GetName(), user.GetId()) && !strings.HasSuffix(room.GetName(), user.GetId()) {
+			return notFound("room not found")
+		}
+	} else if room.GetOwnerId() != user.GetId() && !p.inRoom(user, room) {
+		return forbidden("join the room before sending messages")
+	}
+	return p.authorizeWrite(user, room)
+}
+
+// inRoom reports whether one of user's connections joined room.
+func (p *Policy) inRoom(user models.IUser, room *Room) bool {
+	for _, client := range p.hub.FindClientsByID(user.GetId()) {
+		if client.IsInRoom(room) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) authorizeWrite(user models.IUser, room *Room) *PolicyError {
+	if !models.HasScope(user, models.ScopeChatWrite) {
+		return forbidden("api key lacks the chat:write scope")
+	}
+	if models.IsGuest(user) && room.GetGuestPolicy() != models.GuestsAllowed {
+		return forbidden("guests cannot send messages in this room")
+	}
+	return nil
+}
+
+func (p *Policy) authorizeJoin(c *Client, roomName string) *PolicyError {
+	if roomName == "" {
+		return notFound("room not found")
+	}
+	if utf8.RuneCountInString(roomName) > maxNameLength {
+		return &PolicyError{Code: ErrorInvalid, Reason: "room name is too long"}
+	}
+	// Direct message rooms are named after their two users, so a public room
+	// under such a name could take over their conversation.
+	if isDirectMessageRoomName(roomName) {
+		return notFound("room not found")
+	}
+	room := p.hub.FindRoomByName(roomName)
+	if room == nil {
+		if !p.allows(p.config.RoomCreation, c) {
+			return forbidden("you may not create rooms")
+		}
+		return nil
+	}
+	// Private rooms are only joined through invitations.
+	if room.Private {
+		return notFound("room not found")
+	}
+	if c.Guest && room.GetGuestPolicy() == models.GuestsDenied {
+		return forbidden("guests cannot join this room")
+	}
+	return nil
+}
+
+func (p *Policy) authorizeDirectMessage(c *Client, targetID string) *PolicyError {
+	target := p.hub.FindUserByID(targetID)
+	if target == nil {
+		return notFound("user is not online")
+	}
+	if target.GetId() == c.GetId() {
+		return nil
+	}
+
+	allow := p.config.DirectMessages
+	if !models.IsGuest(target) && !models.IsBot(target) {
+		chosen, err := p.hub.UserRepository.FindDirectMessagePolicy(target.GetId())
+		if err != nil {
+			log.Println(err, "authorizeDirectMessage()")
+			return &PolicyError{Code: ErrorInternal, Reason: "could not check the user's settings"}
+		}
+		if chosen != "" {
+			allow = chosen
+		}
+	}
+	if !p.allows(allow, c) {
+		return forbidden("the user does not accept direct messages from you")
+	}
+	return nil
+}
+
+func (p *Policy) authorizeRoomOwner(c *Client, message *Message) *PolicyError {
+	if message.Target == nil {
+		return notFound("room not found")
+	}
+	room := p.hub.FindRoomByID(message.Target.GetId())
+	if room == nil {
+		return notFound("room not found")
+	}
+	if c.Guest || room.GetOwnerId() != c.GetId() {
+		return forbidden("only the room owner can do this")
+	}
+	return nil
+}
+
+// allows reports whether c is among the users allow names.
+func (p *Policy) allows(allow string, c *Client) bool {
+	switch allow {
+	case models.AllowAnyone:
+		return true
+	case models.AllowRegistered:
+		return !c.Guest
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/issy20/go-websocket/auth"
+	"github.com/issy20/go-websocket/models"
+)
+
+func TestAuthorizePost(t *testing.T) {
+	hub, _, _ := newTestHub(t)
+	owner := testUser{id: uuid.New().String(), name: "owner"}
+	room, err := hub.CreateRoom("lobby", false, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newTestServer(t, hub)
+
+	member := testUser{id: uuid.New().String(), name: "member"}
+	memberConn, err := dialAs(server, member)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer memberConn.Close()
+	if _, err := joinRoom(memberConn, room.GetName()); err != nil {
+		t.Fatal(err)
+	}
+	guest := auth.NewGuest("visitor")
+	guestConn, err := dialAs(server, guest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer guestConn.Close()
+	if _, err := joinRoom(guestConn, room.GetName()); err != nil {
+		t.Fatal(err)
+	}
+
+	bot := &auth.BotUser{Id: uuid.New().String(), Name: "bot", Scopes: []string{models.ScopeChatRead, models.ScopeChatWrite}}
+	readBot := &auth.BotUser{Id: uuid.New().String(), Name: "reader", Scopes: []string{models.ScopeChatRead}}
+	for _, user := range []models.IUser{bot, readBot} {
+		conn, err := dialAs(server, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := joinRoom(conn, room.GetName()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	strangerBot := &auth.BotUser{Id: uuid.New().String(), Name: "stranger bot", Scopes: []string{models.ScopeChatWrite}}
+	tests := []struct {
+		name   string
+		user   models.IUser
+		policy string
+		want   string
+	}{
+		{"owner", owner, models.GuestsAllowed, ""},
+		{"member", member, models.GuestsAllowed, ""},
+		{"stranger", testUser{id: uuid.New().String(), name: "stranger"}, models.GuestsAllowed, ErrorForbidden},
+		{"guest member", guest, models.GuestsAllowed, ""},
+		{"read-only guest member", guest, models.GuestsReadOnly, ErrorForbidden},
+		{"guest stranger", auth.NewGuest("stranger"), models.GuestsAllowed, ErrorForbidden},
+		{"bot member", bot, models.GuestsAllowed, ""},
+		{"bot member without chat:write", readBot, models.GuestsAllowed, ErrorForbidden},
+		{"bot stranger", strangerBot, models.GuestsAllowed, ErrorForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room.SetGuestPolicy(tt.policy)
+			err := hub.Policy.AuthorizePost(tt.user, room)
+			if got := policyCode(err); got != tt.want {
+				t.Fatalf("got %q (%v), want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// Membership is only known to the node a client is connected to, so a
+// member connected to another node cannot post through this one.
+func TestAuthorizePostMemberOfOtherNode(t *testing.T) {
+	hub, roomRepository, messageRepository := newTestHub(t)
+	other := NewHub(roomRepository, fakeUserRepository{}, messageRepository, hub.ClientConfig, hub.Broker)
+	owner := testUser{id: uuid.New().String(), name: "owner"}
+	room, err := hub.CreateRoom("lobby", false, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	member := testUser{id: uuid.New().String(), name: "member"}
+	conn, err := dialAs(newTestServer(t, other), member)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := joinRoom(conn, room.GetName()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := other.Policy.AuthorizePost(member, other.FindRoomByName(room.GetName())); err != nil {
+		t.Fatalf("member refused on its own node: %v", err)
+	}
+	if err := hub.Policy.AuthorizePost(member, room); policyCode(err) != ErrorForbidden {
+		t.Fatalf("member on another node got %v, want forbidden", err)
+	}
+}
+
+func TestAuthorizePostPrivateRoom(t *testing.T) {
+	hub, _, _ := newTestHub(t)
+	alice := testUser{id: uuid.New().String(), name: "alice"}
+	bob := testUser{id: uuid.New().String(), name: "bob"}
+	room, err := hub.CreateRoom(directMessageRoomName(alice.id, bob.id), true, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hub.Policy.AuthorizePost(bob, room); err != nil {
+		t.Fatalf("participant refused: %v", err)
+	}
+	if err := hub.Policy.AuthorizePost(testUser{id: uuid.New().String()}, room); policyCode(err) != ErrorNotFound {
+		t.Fatalf("outsider got %v, want not found", err)
+	}
+}
+
+func TestAuthorizeJoinRoomName(t *testing.T) {
+	hub, _, _ := newTestHub(t)
+	server := newTestServer(t, hub)
+	conn, err := dial(server, uuid.New().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		name     string
+		roomName string
+		want     string
+	}{
+		{"direct message name", directMessageRoomName(uuid.New().String(), uuid.New().String()), ErrorNotFound},
+		{"too long", strings.Repeat("r", maxNameLength+1), ErrorInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := joinRoomError(t, conn, JoinRoomAction, tt.roomName); code != tt.want {
+				t.Fatalf("got %q, want %q", code, tt.want)
+			}
+			if hub.FindRoomByName(tt.roomName) != nil {
+				t.Fatal("room was created")
+			}
+		})
+	}
+}
+
+func TestDirectMessageRefusesPublicRoom(t *testing.T) {
+	hub, _, _ := newTestHub(t)
+	server := newTestServer(t, hub)
+	go hub.ListenPubSubChannel()
+	alice := testUser{id: uuid.New().String(), name: "alice"}
+	bob := testUser{id: uuid.New().String(), name: "bob"}
+	// A public room left over from before direct message names were reserved.
+	if _, err := hub.CreateRoom(directMessageRoomName(bob.id, alice.id), false, bob); err != nil {
+		t.Fatal(err)
+	}
+
+	bobConn, err := dialAs(server, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bobConn.Close()
+	aliceConn, err := dialAs(server, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aliceConn.Close()
+	waitFor(t, "bob did not come online", func() bool { return hub.FindUserByID(bob.id) != nil })
+
+	if code := joinRoomError(t, aliceConn, JoinRoomPrivateAction, bob.id); code != ErrorInternal {
+		t.Fatalf("got %q, want %q", code, ErrorInternal)
+	}
+}
+
+// joinRoomError sends action with message and returns the code of the error
+// the server answers with.
+func joinRoomError(t *testing.T, conn *websocket.Conn, action string, message string) string {
+	t.Helper()
+	join := &Message{Action: action, Message: message}
+	if err := conn.WriteMessage(websocket.TextMessage, join.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	var code string
+	err := readUntil(conn, func(message *testMessage) bool {
+		if message.Action == RoomJoinedAction {
+			return true
+		}
+		if message.Action == ErrorAction && message.Error != nil && message.Error.Action == action {
+			code = message.Error.Code
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func policyCode(err *PolicyError) string {
+	if err == nil {
+		return ""
+	}
+	return err.Code
+}
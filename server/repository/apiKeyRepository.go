@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/issy20/go-websocket/models"
+)
+
+type APIKeyRepository struct {
+	Db *sql.DB
+}
+
+func (kr *APIKeyRepository) AddAPIKey(key *models.APIKey, keyHash string) error {
+	_, err := kr.Db.Exec("INSERT INTO api_keys(id, user_id, name, key_hash, scopes, created_at, expires_at) values(?, ?, ?, ?, ?, ?, ?)",
+		key.ID, key.UserID, key.Name, keyHash, strings.Join(key.Scopes, " "), key.CreatedAt, key.ExpiresAt)
+	return err
+}
+
+func scanAPIKey(scan func(dest ...interface{}) error, extra ...interface{}) (*models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	var expiresAt, revokedAt sql.NullTime
+	dest := append([]interface{}{&key.ID, &key.UserID, &key.Name, &scopes, &key.CreatedAt, &expiresAt, &revokedAt}, extra...)
+	if err := scan(dest...); err != nil {
+		return nil, err
+	}
+	key.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return &key, nil
+}
+
+func (kr *APIKeyRepository) FindAPIKeyByHash(keyHash string) (*models.APIKey, error) {
+	row := kr.Db.QueryRow(`SELECT k.id, k.user_id, k.name, k.scopes, k.created_at, k.expires_at, k.revoked_at, u.name
+		FROM api_keys k JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = ? LIMIT 1`, keyHash)
+	var userName string
+	key, err := scanAPIKey(row.Scan, &userName)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, models.ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	key.UserName = userName
+
+	switch {
+	case key.RevokedAt != nil:
+		return nil, models.ErrAPIKeyRevoked
+	case key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt):
+		return nil, models.ErrAPIKeyExpired
+	}
+	return key, nil
+}
+
+func (kr *APIKeyRepository) FindAPIKeysByUserId(userID string) ([]*models.APIKey, error) {
+	rows, err := kr.Db.Query("SELECT id, user_id, name, scopes, created_at, expires_at, revoked_at FROM api_keys WHERE user_id = ? ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []*models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (kr *APIKeyRepository) RevokeAPIKey(id string, userID string) error {
+	res, err := kr.Db.Exec("UPDATE api_keys SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL", time.Now(), id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return models.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (kr *APIKeyRepository) RevokeAPIKeysByUserId(userID string) error {
+	_, err := kr.Db.Exec("UPDATE api_keys SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", time.Now(), userID)
+	return err
+}
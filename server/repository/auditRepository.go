@@ -0,0 +1,17 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/issy20/go-websocket/models"
+)
+
+type AuditRepository struct {
+	Db *sql.DB
+}
+
+func (ar *AuditRepository) AddEvent(event *models.AuditEvent) error {
+	_, err := ar.Db.Exec("INSERT INTO audit_events(type, user_id, username, ip, detail, created_at) values(?, ?, ?, ?, ?, ?)",
+		event.Type, sql.NullString{String: event.UserID, Valid: event.UserID != ""}, event.Username, event.IP, event.Detail, event.CreatedAt)
+	return err
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/issy20/go-websocket/models"
+)
+
+type AuthSessionRepository struct {
+	Db *sql.DB
+}
+
+func (ar *AuthSessionRepository) CreateSession(id string, userID string) error {
+	_, err := ar.Db.Exec("INSERT INTO auth_sessions(id, user_id, created_at) values(?, ?, ?)", id, userID, time.Now())
+	return err
+}
+
+func (ar *AuthSessionRepository) RevokeSession(id string) error {
+	_, err := ar.Db.Exec("UPDATE auth_sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", time.Now(), id)
+	return err
+}
+
+func (ar *AuthSessionRepository) RevokeUserSessions(userID string, exceptID string) ([]string, error) {
+	rows, err := ar.Db.Query("SELECT id FROM auth_sessions WHERE user_id = ? AND id <> ? AND revoked_at IS NULL", userID, exceptID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if err := ar.RevokeSession(id); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+func (ar *AuthSessionRepository) IsSessionRevoked(id string) (bool, error) {
+	var revokedAt sql.NullTime
+	row := ar.Db.QueryRow("SELECT revoked_at FROM auth_sessions WHERE id = ? LIMIT 1", id)
+	if err := row.Scan(&revokedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return true, nil
+		}
+		return false, err
+	}
+	return revokedAt.Valid, nil
+}
+
+func (ar *AuthSessionRepository) AddRefreshToken(tokenHash string, sessionID string, expiresAt time.Time) error {
+	_, err := ar.Db.Exec("INSERT INTO refresh_tokens(token_hash, session_id, expires_at) values(?, ?, ?)", tokenHash, sessionID, expiresAt)
+	return err
+}
+
+func (ar *AuthSessionRepository) UseRefreshToken(tokenHash string) (string, string, error) {
+	tx, err := ar.Db.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	var sessionID, userID string
+	var expiresAt time.Time
+	var usedAt, revokedAt sql.NullTime
+	row := tx.QueryRow(`SELECT t.session_id, s.user_id, t.expires_at, t.used_at, s.revoked_at
+		FROM refresh_tokens t JOIN auth_sessions s ON s.id = t.session_id
+		WHERE t.token_hash = ? LIMIT 1 FOR UPDATE`, tokenHash)
+	if err := row.Scan(&sessionID, &userID, &expiresAt, &usedAt, &revokedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", models.ErrRefreshTokenNotFound
+		}
+		return "", "", err
+	}
+
+	switch {
+	case revokedAt.Valid:
+		return sessionID, userID, models.ErrSessionRevoked
+	case usedAt.Valid:
+		return sessionID, userID, models.ErrRefreshTokenReused
+	case time.Now().After(expiresAt):
+		return sessionID, userID, models.ErrRefreshTokenExpired
+	}
+
+	if _, err := tx.Exec("UPDATE refresh_tokens SET used_at = ? WHERE token_hash = ?", time.Now(), tokenHash); err != nil {
+		return "", "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", "", err
+	}
+	return sessionID, userID, nil
+}
+
+func (ar *AuthSessionRepository) FindSessionByRefreshToken(tokenHash string) (string, string, error) {
+	var sessionID, userID string
+	row := ar.Db.QueryRow(`SELECT t.session_id, s.user_id
+		FROM refresh_tokens t JOIN auth_sessions s ON s.id = t.session_id
+		WHERE t.token_hash = ? LIMIT 1`, tokenHash)
+	if err := row.Scan(&sessionID, &userID); err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", models.ErrRefreshTokenNotFound
+		}
+		return "", "", err
+	}
+	return sessionID, userID, nil
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/issy20/go-websocket/models"
+)
+
+type IncomingWebhookRepository struct {
+	Db *sql.DB
+}
+
+func (ir *IncomingWebhookRepository) AddIncomingWebhook(hook *models.IncomingWebhook, tokenHash string) error {
+	_, err := ir.Db.Exec("INSERT INTO incoming_webhooks(id, room_id, name, token_hash, created_by, created_at) values(?, ?, ?, ?, ?, ?)",
+		hook.ID, hook.RoomID, hook.Name, tokenHash, hook.CreatedBy, hook.CreatedAt)
+	return err
+}
+
+const incomingWebhookColumns = "id, room_id, name, created_by, created_at, revoked_at"
+
+func scanIncomingWebhook(scan func(dest ...interface{}) error) (*models.IncomingWebhook, error) {
+	var hook models.IncomingWebhook
+	var revokedAt sql.NullTime
+	if err := scan(&hook.ID, &hook.RoomID, &hook.Name, &hook.CreatedBy, &hook.CreatedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		hook.RevokedAt = &revokedAt.Time
+	}
+	return &hook, nil
+}
+
+func (ir *IncomingWebhookRepository) FindIncomingWebhookByHash(tokenHash string) (*models.IncomingWebhook, error) {
+	row := ir.Db.QueryRow("SELECT "+incomingWebhookColumns+" FROM incoming_webhooks WHERE token_hash = ? LIMIT 1", tokenHash)
+	hook, err := scanIncomingWebhook(row.Scan)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, models.ErrIncomingWebhookNotFound
+		}
+		return nil, err
+	}
+	if hook.RevokedAt != nil {
+		return nil, models.ErrIncomingWebhookRevoked
+	}
+	return hook, nil
+}
+
+func (ir *IncomingWebhookRepository) FindIncomingWebhooksByRoomId(roomID string) ([]*models.IncomingWebhook, error) {
+	rows, err := ir.Db.Query("SELECT "+incomingWebhookColumns+" FROM incoming_webhooks WHERE room_id = ? ORDER BY created_at", roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hooks := []*models.IncomingWebhook{}
+	for rows.Next() {
+		hook, err := scanIncomingWebhook(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
+
+func (ir *IncomingWebhookRepository) RevokeIncomingWebhook(id string, roomID string) error {
+	res, err := ir.Db.Exec("UPDATE incoming_webhooks SET revoked_at = ? WHERE id = ? AND room_id = ? AND revoked_at IS NULL", time.Now(), id, roomID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return models.ErrIncomingWebhookNotFound
+	}
+	return nil
+}
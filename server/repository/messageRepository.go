@@ -0,0 +1,17 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/issy20/go-websocket/models"
+)
+
+type MessageRepository struct {
+	Db *sql.DB
+}
+
+func (mr *MessageRepository) AddMessage(message *models.ChatMessage) error {
+	_, err := mr.Db.Exec("INSERT INTO messages(id, room_id, sender_id, body, created_at) values(?, ?, ?, ?, ?)",
+		message.ID, message.RoomID, message.SenderID, message.Body, message.CreatedAt)
+	return err
+}
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/issy20/go-websocket/models"
+)
+
+type PasswordResetRepository struct {
+	Db *sql.DB
+}
+
+func (pr *PasswordResetRepository) AddResetToken(tokenHash string, userID string, expiresAt time.Time) error {
+	_, err := pr.Db.Exec("INSERT INTO password_reset_tokens(token_hash, user_id, expires_at) values(?, ?, ?)", tokenHash, userID, expiresAt)
+	return err
+}
+
+func (pr *PasswordResetRepository) UseResetToken(tokenHash string) (string, error) {
+	tx, err := pr.Db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var userID string
+	var expiresAt time.Time
+	var usedAt sql.NullTime
+	row := tx.QueryRow("SELECT user_id, expires_at, used_at FROM password_reset_tokens WHERE token_hash = ? LIMIT 1 FOR UPDATE", tokenHash)
+	if err := row.Scan(&userID, &expiresAt, &usedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return "", models.ErrResetTokenNotFound
+		}
+		return "", err
+	}
+
+	switch {
+	case usedAt.Valid:
+		return "", models.ErrResetTokenUsed
+	case time.Now().After(expiresAt):
+		return "", models.ErrResetTokenExpired
+	}
+
+	if _, err := tx.Exec("UPDATE password_reset_tokens SET used_at = ? WHERE token_hash = ?", time.Now(), tokenHash); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return userID, nil
+}
@@ -7,9 +7,11 @@ import (
 )
 
 type Room struct {
-	Id      string
-	Name    string
-	Private bool
+	Id          string
+	Name        string
+	Private     bool
+	OwnerId     string
+	GuestPolicy string
 }
 
 func (r *Room) GetId() string {
@@ -24,32 +26,65 @@ func (r *Room) GetPrivate() bool {
 	return r.Private
 }
 
+func (r *Room) GetOwnerId() string {
+	return r.OwnerId
+}
+
+func (r *Room) GetGuestPolicy() string {
+	return r.GuestPolicy
+}
+
 type RoomRepository struct {
 	Db *sql.DB
 }
 
-func (rr *RoomRepository) AddRoom(room models.Room) {
-	stmt, err := rr.Db.Prepare("INSERT INTO rooms(id, name, private) values (?, ?, ?)")
-	checkErr(err)
-
-	_, err = stmt.Exec(room.GetId(), room.GetName(), room.GetPrivate())
-	checkErr(err)
+func (rr *RoomRepository) AddRoom(room models.Room) error {
+	var ownerID sql.NullString
+	if room.GetOwnerId() != "" {
+		ownerID = sql.NullString{String: room.GetOwnerId(), Valid: true}
+	}
+	_, err := rr.Db.Exec("INSERT INTO rooms(id, name, private, owner_id, guest_policy) values (?, ?, ?, ?, ?)",
+		room.GetId(), room.GetName(), room.GetPrivate(), ownerID, room.GetGuestPolicy())
+	return err
 }
 
 func (rr *RoomRepository) FindRoomByName(name string) models.Room {
-	row := rr.Db.QueryRow("SELECT id, name, private FROM rooms where name = ? LIMIT 1", name)
+	row := rr.Db.QueryRow("SELECT id, name, private, owner_id, guest_policy FROM rooms where name = ? LIMIT 1", name)
 	var room Room
+	var ownerID sql.NullString
 
-	if err := row.Scan(&room.Id, &room.Name, &room.Private); err != nil {
+	if err := row.Scan(&room.Id, &room.Name, &room.Private, &ownerID, &room.GuestPolicy); err != nil {
 		if err == sql.ErrNoRows {
 			return nil
 		}
 		panic(err)
 	}
+	room.OwnerId = ownerID.String
 
 	return &room
 }
 
+func (rr *RoomRepository) FindRoomById(id string) models.Room {
+	row := rr.Db.QueryRow("SELECT id, name, private, owner_id, guest_policy FROM rooms where id = ? LIMIT 1", id)
+	var room Room
+	var ownerID sql.NullString
+
+	if err := row.Scan(&room.Id, &room.Name, &room.Private, &ownerID, &room.GuestPolicy); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		panic(err)
+	}
+	room.OwnerId = ownerID.String
+
+	return &room
+}
+
+func (rr *RoomRepository) SetGuestPolicy(roomID string, policy string) error {
+	_, err := rr.Db.Exec("UPDATE rooms SET guest_policy = ? WHERE id = ?", policy, roomID)
+	return err
+}
+
 func checkErr(err error) {
 	if err != nil {
 		panic(err)
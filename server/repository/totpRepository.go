@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/issy20/go-websocket/models"
+)
+
+type TOTPRepository struct {
+	Db *sql.DB
+}
+
+func (tr *TOTPRepository) SetSecret(userID string, secret string) error {
+	_, err := tr.Db.Exec(`INSERT INTO user_totp(user_id, secret, enabled_at, last_step, created_at) values(?, ?, NULL, 0, ?)
+		ON DUPLICATE KEY UPDATE secret = VALUES(secret), enabled_at = NULL, last_step = 0, created_at = VALUES(created_at)`,
+		userID, secret, time.Now())
+	return err
+}
+
+func (tr *TOTPRepository) FindTOTP(userID string) (*models.TOTP, error) {
+	var totp models.TOTP
+	var enabledAt sql.NullTime
+	row := tr.Db.QueryRow("SELECT user_id, secret, enabled_at, last_step FROM user_totp WHERE user_id = ? LIMIT 1", userID)
+	if err := row.Scan(&totp.UserID, &totp.Secret, &enabledAt, &totp.LastStep); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	totp.Enabled = enabledAt.Valid
+	return &totp, nil
+}
+
+func (tr *TOTPRepository) EnableTOTP(userID string, recoveryCodeHashes []string) error {
+	tx, err := tr.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE user_totp SET enabled_at = ? WHERE user_id = ?", time.Now(), userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM totp_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		if _, err := tx.Exec("INSERT INTO totp_recovery_codes(code_hash, user_id) values(?, ?)", hash, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (tr *TOTPRepository) UseStep(userID string, step int64) (bool, error) {
+	res, err := tr.Db.Exec("UPDATE user_totp SET last_step = ? WHERE user_id = ? AND last_step < ?", step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (tr *TOTPRepository) UseRecoveryCode(userID string, codeHash string) (bool, error) {
+	res, err := tr.Db.Exec("UPDATE totp_recovery_codes SET used_at = ? WHERE code_hash = ? AND user_id = ? AND used_at IS NULL", time.Now(), codeHash, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (tr *TOTPRepository) DeleteTOTP(userID string) error {
+	tx, err := tr.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM totp_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_totp WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
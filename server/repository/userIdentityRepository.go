@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/issy20/go-websocket/models"
+)
+
+type UserIdentityRepository struct {
+	Db *sql.DB
+}
+
+func (ir *UserIdentityRepository) FindUserIdByIdentity(issuer string, subject string) (string, error) {
+	var userID string
+	row := ir.Db.QueryRow("SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ? LIMIT 1", issuer, subject)
+	if err := row.Scan(&userID); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+	return userID, nil
+}
+
+func (ir *UserIdentityRepository) AddIdentity(issuer string, subject string, userID string) error {
+	_, err := ir.Db.Exec("INSERT INTO user_identities(issuer, subject, user_id) values(?, ?, ?)", issuer, subject, userID)
+	return err
+}
+
+func (ir *UserIdentityRepository) AddUserWithIdentity(issuer string, subject string, id string, name string, username string, email string, password string) error {
+	tx, err := ir.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("INSERT INTO users(id, name, username, email, password) values(?, ?, ?, ?, ?)", id, name, username, sql.NullString{String: email, Valid: email != ""}, password); err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+			return models.ErrDuplicateUsername
+		}
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO user_identities(issuer, subject, user_id) values(?, ?, ?)", issuer, subject, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (ir *UserIdentityRepository) HasIdentity(userID string) (bool, error) {
+	var n int
+	row := ir.Db.QueryRow("SELECT COUNT(*) FROM user_identities WHERE user_id = ?", userID)
+	if err := row.Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (ir *UserIdentityRepository) RemoveIdentities(userID string) error {
+	_, err := ir.Db.Exec("DELETE FROM user_identities WHERE user_id = ?", userID)
+	return err
+}
@@ -2,16 +2,26 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
 	"log"
+	"strings"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/issy20/go-websocket/models"
 )
 
+// mysqlDuplicateEntry is the MySQL error number of a unique key violation.
+const mysqlDuplicateEntry = 1062
+
 type User struct {
-	Id       string `json:"id"`
-	Name     string `json:"name"`
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	Username  string `json:"username"`
+	Email     string `json:"email,omitempty"`
+	Password  string `json:"password,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	OwnerId   string `json:"owner_id,omitempty"`
+	AvatarURL string `json:"avatar_url,omitempty"`
 }
 
 func (user *User) GetId() string {
@@ -26,10 +36,14 @@ type UserRepository struct {
 	Db *sql.DB
 }
 
-func (ur *UserRepository) AddUser(id string, name string, username string, password string) error {
-	stmt, err := ur.Db.Prepare("INSERT INTO users(id, name, username, password) values(?, ?, ?, ?)")
+func (ur *UserRepository) AddUser(id string, name string, username string, email string, password string) error {
+	stmt, err := ur.Db.Prepare("INSERT INTO users(id, name, username, email, password) values(?, ?, ?, ?, ?)")
 	checkErr(err)
-	if _, err := stmt.Exec(id, name, username, password); err != nil {
+	if _, err := stmt.Exec(id, name, username, sql.NullString{String: email, Valid: email != ""}, password); err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+			return models.ErrDuplicateUsername
+		}
 		return err
 	}
 	return nil
@@ -82,5 +96,124 @@ func (ur *UserRepository) FindUserByUsername(username string) *User {
 	return &user
 }
 
+func (ur *UserRepository) FindUserByEmail(email string) *User {
+	row := ur.Db.QueryRow("SELECT id, name, username, email FROM users WHERE email = ? LIMIT 1", email)
+	var user User
+	if err := row.Scan(&user.Id, &user.Name, &user.Username, &user.Email); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		panic(err)
+	}
+	return &user
+}
+
+func (ur *UserRepository) FindUserWithPasswordById(ID string) *User {
+	row := ur.Db.QueryRow("SELECT id, name, username, password FROM users WHERE id = ? LIMIT 1", ID)
+	var user User
+	if err := row.Scan(&user.Id, &user.Name, &user.Username, &user.Password); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		panic(err)
+	}
+	return &user
+}
+
+// AddServiceAccount creates a service account owned by ownerID. Service
+// accounts have no password and can only authenticate with API keys.
+func (ur *UserRepository) AddServiceAccount(id string, name string, ownerID string) error {
+	_, err := ur.Db.Exec("INSERT INTO users(id, name, username, password, kind, owner_id) values(?, ?, ?, '', ?, ?)",
+		id, name, "service:"+id, models.UserKindService, ownerID)
+	return err
+}
+
+func (ur *UserRepository) FindServiceAccountsByOwnerId(ownerID string) ([]*User, error) {
+	rows, err := ur.Db.Query("SELECT id, name, kind, owner_id FROM users WHERE kind = ? AND owner_id = ?", models.UserKindService, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []*User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.Id, &user.Name, &user.Kind, &user.OwnerId); err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	return users, rows.Err()
+}
+
+func (ur *UserRepository) FindServiceAccount(id string, ownerID string) *User {
+	row := ur.Db.QueryRow("SELECT id, name, kind, owner_id FROM users WHERE id = ? AND kind = ? AND owner_id = ? LIMIT 1", id, models.UserKindService, ownerID)
+	var user User
+	if err := row.Scan(&user.Id, &user.Name, &user.Kind, &user.OwnerId); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		panic(err)
+	}
+	return &user
+}
+
+// FindUsers returns a page of users whose name or username contains query,
+// ordered by name.
+func (ur *UserRepository) FindUsers(query string, limit int, offset int) ([]*User, error) {
+	like := "%" + strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(query) + "%"
+	rows, err := ur.Db.Query(`SELECT id, name, kind, COALESCE(avatar_url, '') FROM users
+		WHERE name LIKE ? OR username LIKE ?
+		ORDER BY name, id LIMIT ? OFFSET ?`, like, like, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []*User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.Id, &user.Name, &user.Kind, &user.AvatarURL); err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	return users, rows.Err()
+}
+
+func (ur *UserRepository) FindProfileById(ID string) (*User, error) {
+	row := ur.Db.QueryRow("SELECT id, name, kind, COALESCE(avatar_url, '') FROM users WHERE id = ? LIMIT 1", ID)
+	var user User
+	if err := row.Scan(&user.Id, &user.Name, &user.Kind, &user.AvatarURL); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (ur *UserRepository) UpdateProfile(id string, name string, avatarURL string) error {
+	_, err := ur.Db.Exec("UPDATE users SET name = ?, avatar_url = ? WHERE id = ?", name, sql.NullString{String: avatarURL, Valid: avatarURL != ""}, id)
+	return err
+}
+
+func (ur *UserRepository) FindDirectMessagePolicy(id string) (string, error) {
+	var allow sql.NullString
+	row := ur.Db.QueryRow("SELECT direct_messages FROM users WHERE id = ? LIMIT 1", id)
+	if err := row.Scan(&allow); err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	return allow.String, nil
+}
+
+func (ur *UserRepository) SetDirectMessagePolicy(id string, allow string) error {
+	_, err := ur.Db.Exec("UPDATE users SET direct_messages = ? WHERE id = ?", allow, id)
+	return err
+}
+
+func (ur *UserRepository) UpdatePassword(id string, password string) error {
+	_, err := ur.Db.Exec("UPDATE users SET password = ? WHERE id = ?", password, id)
+	return err
+}
+
 // insert into users(id, name, username, password) values("user1", "Taro", "Taro","password");
 // insert into users(id, name, username, password) values("user2", "Jiro", "Jiro","password");
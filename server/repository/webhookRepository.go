@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/issy20/go-websocket/models"
+)
+
+type WebhookRepository struct {
+	Db *sql.DB
+}
+
+func (wr *WebhookRepository) AddWebhook(hook *models.Webhook) error {
+	_, err := wr.Db.Exec("INSERT INTO webhooks(id, url, secret, events, created_by, created_at) values(?, ?, ?, ?, ?, ?)",
+		hook.ID, hook.URL, hook.Secret, strings.Join(hook.Events, " "), hook.CreatedBy, hook.CreatedAt)
+	return err
+}
+
+func (wr *WebhookRepository) FindWebhooks() ([]*models.Webhook, error) {
+	rows, err := wr.Db.Query("SELECT id, url, secret, events, created_by, created_at FROM webhooks ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var hooks []*models.Webhook
+	for rows.Next() {
+		var hook models.Webhook
+		var events string
+		if err := rows.Scan(&hook.ID, &hook.URL, &hook.Secret, &events, &hook.CreatedBy, &hook.CreatedAt); err != nil {
+			return nil, err
+		}
+		hook.Events = strings.Fields(events)
+		hooks = append(hooks, &hook)
+	}
+	return hooks, rows.Err()
+}
+
+func (wr *WebhookRepository) DeleteWebhook(id string) error {
+	res, err := wr.Db.Exec("DELETE FROM webhooks WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return models.ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (wr *WebhookRepository) AddDelivery(delivery *models.WebhookDelivery) error {
+	_, err := wr.Db.Exec("INSERT INTO webhook_deliveries(id, webhook_id, event, payload, state, attempts, created_at, updated_at) values(?, ?, ?, ?, ?, ?, ?, ?)",
+		delivery.ID, delivery.WebhookID, delivery.Event, string(delivery.Payload), delivery.State, delivery.Attempts, delivery.CreatedAt, delivery.UpdatedAt)
+	return err
+}
+
+func (wr *WebhookRepository) UpdateDelivery(delivery *models.WebhookDelivery) error {
+	_, err := wr.Db.Exec("UPDATE webhook_deliveries SET state = ?, attempts = ?, last_status = ?, last_error = ?, updated_at = ? WHERE id = ?",
+		delivery.State, delivery.Attempts,
+		sql.NullInt64{Int64: int64(delivery.LastStatus), Valid: delivery.LastStatus != 0},
+		sql.NullString{String: delivery.LastError, Valid: delivery.LastError != ""},
+		delivery.UpdatedAt, delivery.ID)
+	return err
+}
+
+const deliveryColumns = "id, webhook_id, event, payload, state, attempts, last_status, last_error, created_at, updated_at"
+
+func scanDelivery(scan func(dest ...interface{}) error) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	var payload string
+	var lastStatus sql.NullInt64
+	var lastError sql.NullString
+	if err := scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &payload, &delivery.State, &delivery.Attempts,
+		&lastStatus, &lastError, &delivery.CreatedAt, &delivery.UpdatedAt); err != nil {
+		return nil, err
+	}
+	delivery.Payload = []byte(payload)
+	delivery.LastStatus = int(lastStatus.Int64)
+	delivery.LastError = lastError.String
+	return &delivery, nil
+}
+
+func (wr *WebhookRepository) FindDelivery(id string) (*models.WebhookDelivery, error) {
+	row := wr.Db.QueryRow("SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE id = ? LIMIT 1", id)
+	delivery, err := scanDelivery(row.Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return delivery, err
+}
+
+func (wr *WebhookRepository) FindDeliveries(webhookID string, state string, limit int) ([]*models.WebhookDelivery, error) {
+	rows, err := wr.Db.Query("SELECT "+deliveryColumns+` FROM webhook_deliveries
+		WHERE (? = '' OR webhook_id = ?) AND (? = '' OR state = ?)
+		ORDER BY created_at DESC LIMIT ?`, webhookID, webhookID, state, state, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []*models.WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func (wr *WebhookRepository) FindPendingDeliveries(updatedBefore time.Time, limit int) ([]*models.WebhookDelivery, error) {
+	rows, err := wr.Db.Query("SELECT "+deliveryColumns+` FROM webhook_deliveries
+		WHERE state = ? AND updated_at < ? ORDER BY updated_at LIMIT ?`, models.DeliveryPending, updatedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deliveries []*models.WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanDelivery(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func (wr *WebhookRepository) ClaimDelivery(delivery *models.WebhookDelivery, now time.Time) (bool, error) {
+	res, err := wr.Db.Exec("UPDATE webhook_deliveries SET updated_at = ? WHERE id = ? AND state = ? AND updated_at = ?",
+		now, delivery.ID, models.DeliveryPending, delivery.UpdatedAt)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if n == 1 {
+		delivery.UpdatedAt = now
+	}
+	return n == 1, err
+}
@@ -1,39 +1,79 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
 
 	"github.com/google/uuid"
 	"github.com/issy20/go-websocket/config"
+	"github.com/issy20/go-websocket/models"
 )
 
 const welcomeMessage = "%s joined the room"
 
+// resumeRequest re-attaches a client to a room and replays what it missed.
+type resumeRequest struct {
+	client *Client
+	after  uint64
+}
+
 type Room struct {
-	ID           uuid.UUID `json:"id"`
-	Name         string    `json:"name"`
-	Clients      map[*Client]bool
-	RegisterCh   chan *Client
-	UnregisterCh chan *Client
-	BroadcastCh  chan *Message
+	ID           uuid.UUID        `json:"id"`
+	Name         string           `json:"name"`
+	Clients      map[*Client]bool `json:"-"`
+	RegisterCh   chan *Client     `json:"-"`
+	UnregisterCh chan *Client     `json:"-"`
+	resumeCh     chan resumeRequest
+	deliverCh    chan []byte
 	Private      bool `json:"private"`
+	// seq numbers the messages delivered to the room; it and history are
+	// only touched by the room loop.
+	seq     uint64
+	history *roomHistory
+	broker  models.Broker
+	// OwnerID is the user who created the room and may change its guest policy.
+	OwnerID     string `json:"-"`
+	policyMu    sync.RWMutex
+	guestPolicy string
+	// quitCh stops the pubsub subscription; subscriptionDoneCh is closed
+	// once the subscription has been released.
+	quitCh             chan struct{}
+	quitOnce           sync.Once
+	subscriptionDoneCh chan struct{}
 }
 
-func NewRoom(name string, private bool) *Room {
+func NewRoom(name string, private bool, clientConfig *config.ClientConfig, broker models.Broker) *Room {
 	return &Room{
 		ID:           uuid.New(),
 		Name:         name,
 		Clients:      make(map[*Client]bool),
 		RegisterCh:   make(chan *Client),
 		UnregisterCh: make(chan *Client),
-		BroadcastCh:  make(chan *Message),
+		resumeCh:     make(chan resumeRequest),
+		deliverCh:    make(chan []byte),
 		Private:      private,
+		history:      newRoomHistory(clientConfig.RoomHistorySize, clientConfig.SessionRetention),
+		broker:       broker,
+		guestPolicy:  clientConfig.DefaultGuestPolicy,
+
+		quitCh:             make(chan struct{}),
+		subscriptionDoneCh: make(chan struct{}),
 	}
 }
 
 func (r *Room) RunRoom() {
-	go r.SubscribeToRoomMessages()
+	// Subscribe before serving registrations so that the join notice a
+	// registration publishes comes back to this room.
+	sub, err := r.broker.Subscribe(ctx, r.GetName())
+	if err != nil {
+		log.Println(err, "RunRoom()")
+		close(r.subscriptionDoneCh)
+	} else {
+		go r.SubscribeToRoomMessages(sub)
+	}
 
 	for {
 		select {
@@ -41,9 +81,47 @@ func (r *Room) RunRoom() {
 			r.RegisterClientInRoom(client)
 		case client := <-r.UnregisterCh:
 			r.UnregisterClientInRoom(client)
-		case message := <-r.BroadcastCh:
-			r.BroadcastToClientsInRoom(message.Encode())
+		case req := <-r.resumeCh:
+			r.ResumeClientInRoom(req.client, req.after)
+		case payload := <-r.deliverCh:
+			var message Message
+			if err := json.Unmarshal(payload, &message); err != nil {
+				log.Printf("room %s: Error on unmarshal JSON message %s", r.GetName(), err)
+				continue
+			}
+			r.deliver(&message)
+		}
+	}
+}
+
+// deliver numbers message, keeps it for replay and sends it to the room.
+func (r *Room) deliver(message *Message) {
+	if message.Action == GuestPolicyAction {
+		r.SetGuestPolicy(message.Message)
+	}
+	r.seq++
+	message.Seq = r.seq
+	encoded := message.Encode()
+	r.history.append(r.seq, encoded)
+	r.BroadcastToClientsInRoom(encoded)
+}
+
+// ResumeClientInRoom registers client again without announcing it and sends
+// it every message after seq, or a resync notice when those are gone.
+func (r *Room) ResumeClientInRoom(client *Client, after uint64) {
+	r.Clients[client] = true
+	payloads, ok := r.history.since(after, r.seq)
+	if !ok {
+		message := &Message{
+			Action: ResyncAction,
+			Target: r,
+			Seq:    r.seq,
 		}
+		client.send(message.Encode())
+		return
+	}
+	for _, payload := range payloads {
+		client.send(payload)
 	}
 }
 
@@ -60,22 +138,46 @@ func (r *Room) UnregisterClientInRoom(client *Client) {
 
 func (r *Room) BroadcastToClientsInRoom(message []byte) {
 	for client := range r.Clients {
-		client.sendCh <- message
+		client.send(message)
 	}
 }
 
 func (r *Room) PublishRoomMessage(message []byte) {
-	err := config.Redis.Publish(ctx, r.GetName(), message).Err()
+	err := r.broker.Publish(ctx, r.GetName(), message)
 	if err != nil {
 		log.Println(err)
 	}
 }
 
-func (r *Room) SubscribeToRoomMessages() {
-	pubsub := config.Redis.Subscribe(ctx, r.GetName())
-	ch := pubsub.Channel()
-	for msg := range ch {
-		r.BroadcastToClientsInRoom([]byte(msg.Payload))
+func (r *Room) SubscribeToRoomMessages(sub models.Subscription) {
+	defer close(r.subscriptionDoneCh)
+	defer r.broker.Unsubscribe(sub)
+	for {
+		select {
+		case payload, ok := <-sub.Messages():
+			if !ok {
+				return
+			}
+			select {
+			case r.deliverCh <- payload:
+			case <-r.quitCh:
+				return
+			}
+		case <-r.quitCh:
+			return
+		}
+	}
+}
+
+// Close releases the room's pubsub subscription. The room loop keeps running
+// so that clients can still unregister while the server drains.
+func (r *Room) Close(ctx context.Context) error {
+	r.quitOnce.Do(func() { close(r.quitCh) })
+	select {
+	case <-r.subscriptionDoneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
@@ -99,3 +201,19 @@ func (r *Room) GetName() string {
 func (r *Room) GetPrivate() bool {
 	return r.Private
 }
+
+func (r *Room) GetOwnerId() string {
+	return r.OwnerID
+}
+
+func (r *Room) GetGuestPolicy() string {
+	r.policyMu.RLock()
+	defer r.policyMu.RUnlock()
+	return r.guestPolicy
+}
+
+func (r *Room) SetGuestPolicy(policy string) {
+	r.policyMu.Lock()
+	defer r.policyMu.Unlock()
+	r.guestPolicy = policy
+}
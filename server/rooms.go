@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/issy20/go-websocket/apierror"
+	"github.com/issy20/go-websocket/auth"
+	"github.com/issy20/go-websocket/models"
+)
+
+type PostMessageInput struct {
+	Message string `json:"message"`
+}
+
+// policyStatus maps the code of a PolicyError to an HTTP status.
+func policyStatus(err *PolicyError) int {
+	switch err.Code {
+	case ErrorNotFound:
+		return http.StatusNotFound
+	case ErrorInvalid:
+		return http.StatusBadRequest
+	case ErrorInternal:
+		return http.StatusInternalServerError
+	}
+	return http.StatusForbidden
+}
+
+// HandleRoom serves /api/rooms/{id}/messages and the room's incoming
+// webhooks under /api/rooms/{id}/webhooks.
+func (api *API) HandleRoom(w http.ResponseWriter, r *http.Request) {
+	roomID, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/rooms/"), "/")
+	resource, hookID, _ := strings.Cut(rest, "/")
+	if roomID == "" || (rest != "messages" && (resource != "webhooks" || strings.Contains(hookID, "/"))) {
+		apierror.Status(w, r, http.StatusNotFound)
+		return
+	}
+	user, ok := r.Context().Value(auth.UserContextKey).(models.IUser)
+	if !ok {
+		apierror.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+	var room *Room
+	if _, err := uuid.Parse(roomID); err == nil {
+		room = api.Hub.LoadRoomByID(roomID)
+	}
+	if room == nil {
+		apierror.Write(w, r, http.StatusNotFound, "room not found")
+		return
+	}
+
+	switch {
+	case rest == "messages":
+		api.postRoomMessage(w, r, user, room)
+	case hookID == "":
+		api.handleIncomingWebhooks(w, r, user, room)
+	default:
+		api.revokeIncomingWebhook(w, r, user, room, hookID)
+	}
+}
+
+// postRoomMessage sends a message to the room as the authenticated user,
+// e.g. a bot using its API key. The message reaches the room's clients the
+// same way as one sent over the WebSocket and is returned with its ID.
+func (api *API) postRoomMessage(w http.ResponseWriter, r *http.Request, user models.IUser, room *Room) {
+	if r.Method != http.MethodPost {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	var input PostMessageInput
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMessageSize)).Decode(&input); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if strings.TrimSpace(input.Message) == "" {
+		apierror.WriteField(w, r, "message", "must not be empty")
+		return
+	}
+	if err := api.Hub.Policy.AuthorizePost(user, room); err != nil {
+		apierror.Write(w, r, policyStatus(err), err.Reason)
+		return
+	}
+
+	id, _ := uuid.Parse(user.GetId())
+	api.postMessage(w, r, room, &Message{
+		Message: input.Message,
+		Sender:  &Client{ID: id, Name: user.GetName(), Guest: models.IsGuest(user), Bot: models.IsBot(user)},
+	})
+}
+
+// postMessage posts message to room and answers with it.
+func (api *API) postMessage(w http.ResponseWriter, r *http.Request, room *Room, message *Message) {
+	if err := api.Hub.PostMessage(room, message); err != nil {
+		log.Println(err, "postMessage()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(message.Encode())
+}
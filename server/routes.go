@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/issy20/go-websocket/apierror"
+	"github.com/issy20/go-websocket/auth"
+)
+
+// route is an endpoint of the public server under /api/.
+type route struct {
+	pattern string
+	handler http.HandlerFunc
+}
+
+// apiRoutes lists the /api endpoints main serves. docs.go documents each of
+// them. The OIDC endpoints are only served when a provider is configured.
+func (api *API) apiRoutes(origins *Origins) []route {
+	var routes []route
+	if api.OIDCProvider != nil {
+		routes = append(routes,
+			route{"/api/oidc/login", api.HandleOIDCLogin},
+			route{"/api/oidc/callback", api.HandleOIDCCallback},
+		)
+	}
+	return append(routes,
+		route{"/api/login", origins.CORS(api.HandleLogin)},
+		route{"/api/login/mfa", origins.CORS(api.HandleLoginMFA)},
+		route{"/api/2fa/enroll", origins.CORS(auth.AuthMiddleware(api.HandleEnrollTOTP))},
+		route{"/api/2fa/enable", origins.CORS(auth.AuthMiddleware(api.HandleEnableTOTP))},
+		route{"/api/2fa/disable", origins.CORS(auth.AuthMiddleware(api.HandleDisableTOTP))},
+		route{"/api/admin/2fa/reset", origins.CORS(auth.AuthMiddleware(api.HandleResetTOTP))},
+		route{"/api/admin/webhooks", origins.CORS(auth.AuthMiddleware(api.HandleWebhooks))},
+		route{"/api/admin/webhooks/delete", origins.CORS(auth.AuthMiddleware(api.HandleDeleteWebhook))},
+		route{"/api/admin/webhooks/deliveries", origins.CORS(auth.AuthMiddleware(api.HandleWebhookDeliveries))},
+		route{"/api/admin/webhooks/deliveries/redeliver", origins.CORS(auth.AuthMiddleware(api.HandleRedeliverWebhook))},
+		route{"/api/create", origins.CORS(api.HandleAddUser)},
+		route{"/api/refresh", origins.CORS(api.HandleRefresh)},
+		route{"/api/logout", origins.CORS(api.HandleLogout)},
+		route{"/api/password", origins.CORS(auth.AuthMiddleware(api.HandleChangePassword))},
+		route{"/api/password/reset", origins.CORS(api.HandleResetPassword)},
+		route{"/api/password/reset/confirm", origins.CORS(api.HandleConfirmResetPassword)},
+		route{"/api/service-accounts", origins.CORS(auth.AuthMiddleware(api.HandleServiceAccounts))},
+		route{"/api/service-accounts/keys", origins.CORS(auth.AuthMiddleware(api.HandleAPIKeys))},
+		route{"/api/service-accounts/keys/revoke", origins.CORS(auth.AuthMiddleware(api.HandleRevokeAPIKey))},
+		route{"/api/users", origins.CORS(auth.AuthMiddleware(api.HandleUsers))},
+		route{"/api/users/", origins.CORS(auth.AuthMiddleware(api.HandleUser))},
+		route{"/api/rooms/", origins.CORS(auth.TokenMiddleware(api.HandleRoom))},
+		route{incomingWebhookPath, api.HandleIncomingWebhook},
+		route{"/api/direct-messages", origins.CORS(auth.AuthMiddleware(api.HandleDirectMessages))},
+		route{"/api/guest", origins.CORS(api.HandleGuest)},
+		route{"/api/guest/upgrade", origins.CORS(auth.AuthMiddleware(api.HandleUpgradeGuest))},
+		route{"/api/", func(w http.ResponseWriter, r *http.Request) {
+			apierror.Status(w, r, http.StatusNotFound)
+		}},
+	)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/issy20/go-websocket/apierror"
+	"github.com/issy20/go-websocket/auth"
+	"github.com/issy20/go-websocket/models"
+)
+
+type ServiceAccountInput struct {
+	Name string `json:"name"`
+}
+
+type APIKeyInput struct {
+	ServiceAccountID string   `json:"service_account_id"`
+	Name             string   `json:"name"`
+	Scopes           []string `json:"scopes"`
+	// ExpiresIn is the lifetime of the key in seconds. Zero keys never expire.
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+type RevokeAPIKeyInput struct {
+	ServiceAccountID string `json:"service_account_id"`
+	ID               string `json:"id"`
+}
+
+type APIKeyResponse struct {
+	*models.APIKey
+	// Key is only returned when the key is created.
+	Key string `json:"key"`
+}
+
+// accountOwner returns the registered user managing service accounts.
+// Guests and service accounts themselves cannot.
+func accountOwner(w http.ResponseWriter, r *http.Request) (models.IUser, bool) {
+	user, ok := r.Context().Value(auth.UserContextKey).(models.IUser)
+	if !ok || models.IsGuest(user) || models.IsBot(user) {
+		apierror.Status(w, r, http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
+
+// admin returns the registered user calling an admin endpoint, refusing
+// everyone not listed in the admin IDs.
+func (api *API) admin(w http.ResponseWriter, r *http.Request) (models.IUser, bool) {
+	user, ok := accountOwner(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !api.AuthConfig.IsAdmin(user.GetId()) {
+		apierror.Status(w, r, http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
+
+// HandleServiceAccounts lists the caller's service accounts on GET and
+// creates one on POST.
+func (api *API) HandleServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	owner, ok := accountOwner(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		accounts, err := api.UserRepository.FindServiceAccountsByOwnerId(owner.GetId())
+		if err != nil {
+			log.Println(err, "HandleServiceAccounts()")
+			apierror.Status(w, r, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(accounts)
+	case http.MethodPost:
+		var input ServiceAccountInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if input.Name == "" {
+			apierror.WriteField(w, r, "name", "is required")
+			return
+		}
+		id := uuid.New().String()
+		if err := api.UserRepository.AddServiceAccount(id, input.Name, owner.GetId()); err != nil {
+			log.Println(err, "HandleServiceAccounts()")
+			apierror.Status(w, r, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(api.UserRepository.FindServiceAccount(id, owner.GetId()))
+	default:
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleAPIKeys lists the keys of ?service_account_id= on GET and creates
+// a key on POST. The key is only ever shown in the POST response.
+func (api *API) HandleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	owner, ok := accountOwner(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		account := api.UserRepository.FindServiceAccount(r.URL.Query().Get("service_account_id"), owner.GetId())
+		if account == nil {
+			apierror.Status(w, r, http.StatusNotFound)
+			return
+		}
+		keys, err := api.APIKeyRepository.FindAPIKeysByUserId(account.Id)
+		if err != nil {
+			log.Println(err, "HandleAPIKeys()")
+			apierror.Status(w, r, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys)
+	case http.MethodPost:
+		var input APIKeyInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		account := api.UserRepository.FindServiceAccount(input.ServiceAccountID, owner.GetId())
+		if account == nil {
+			apierror.Status(w, r, http.StatusNotFound)
+			return
+		}
+		if len(input.Scopes) == 0 {
+			apierror.WriteField(w, r, "scopes", "are required")
+			return
+		}
+		for _, scope := range input.Scopes {
+			if !models.ValidScope(scope) {
+				apierror.WriteField(w, r, "scopes", "unknown scope: "+scope)
+				return
+			}
+		}
+
+		key, hash, err := auth.NewAPIKey()
+		if err != nil {
+			apierror.Status(w, r, http.StatusInternalServerError)
+			return
+		}
+		apiKey := &models.APIKey{
+			ID:        uuid.New().String(),
+			UserID:    account.Id,
+			Name:      input.Name,
+			Scopes:    input.Scopes,
+			CreatedAt: time.Now(),
+		}
+		if input.ExpiresIn > 0 {
+			expiresAt := apiKey.CreatedAt.Add(time.Duration(input.ExpiresIn) * time.Second)
+			apiKey.ExpiresAt = &expiresAt
+		}
+		if err := api.APIKeyRepository.AddAPIKey(apiKey, hash); err != nil {
+			log.Println(err, "HandleAPIKeys()")
+			apierror.Status(w, r, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(&APIKeyResponse{APIKey: apiKey, Key: key})
+	default:
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleRevokeAPIKey revokes a key. Connections already opened with it stay
+// open until they reconnect.
+func (api *API) HandleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	owner, ok := accountOwner(w, r)
+	if !ok {
+		return
+	}
+	var input RevokeAPIKeyInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	account := api.UserRepository.FindServiceAccount(input.ServiceAccountID, owner.GetId())
+	if account == nil {
+		apierror.Status(w, r, http.StatusNotFound)
+		return
+	}
+	err := api.APIKeyRepository.RevokeAPIKey(input.ID, account.Id)
+	if errors.Is(err, models.ErrAPIKeyNotFound) {
+		apierror.Status(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err, "HandleRevokeAPIKey()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+// Session outlives a single connection so that a client reconnecting after a
+// network blip can get its rooms back. Sessions and room histories live in
+// the memory of one node, so resuming requires reconnecting to the same node.
+type Session struct {
+	Token  string
+	UserID string
+
+	mu     sync.Mutex
+	client *Client
+	rooms  map[string]bool
+	// expiresAt is zero while a client is attached.
+	expiresAt time.Time
+}
+
+func (s *Session) addRoom(roomID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rooms[roomID] = true
+}
+
+func (s *Session) removeRoom(roomID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.rooms, roomID)
+}
+
+func (s *Session) roomIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.rooms))
+	for id := range s.rooms {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func newSessionToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewSession issues a resumable session for client.
+func (h *Hub) NewSession(client *Client) (*Session, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{
+		Token:  token,
+		UserID: client.GetId(),
+		client: client,
+		rooms:  make(map[string]bool),
+	}
+	h.sessions.Store(token, session)
+	return session, nil
+}
+
+// DetachSession starts the retention window of client's session.
+func (h *Hub) DetachSession(client *Client) {
+	session := client.session
+	if session == nil {
+		return
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.client == client {
+		session.client = nil
+		session.expiresAt = time.Now().Add(h.ClientConfig.SessionRetention)
+	}
+}
+
+// ResumeSession hands the session identified by token over to client. It
+// returns nil when the session is unknown, expired or belongs to another user.
+// A connection still holding the session is closed.
+func (h *Hub) ResumeSession(token string, client *Client) *Session {
+	session, ok := h.sessions.Load(token)
+	if !ok || session.UserID != client.GetId() {
+		return nil
+	}
+
+	session.mu.Lock()
+	if !session.expiresAt.IsZero() && time.Now().After(session.expiresAt) {
+		session.mu.Unlock()
+		h.sessions.Delete(token)
+		return nil
+	}
+	previous := session.client
+	session.client = client
+	session.expiresAt = time.Time{}
+	session.mu.Unlock()
+
+	if previous != nil && previous != client {
+		previous.Close(closeSessionResumed, "session resumed on another connection")
+	}
+	return session
+}
+
+func (h *Hub) expireSessions() {
+	ticker := time.NewTicker(h.ClientConfig.SessionRetention)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, session := range h.sessions.Values() {
+				session.mu.Lock()
+				expired := !session.expiresAt.IsZero() && now.After(session.expiresAt)
+				session.mu.Unlock()
+				if expired {
+					h.sessions.Delete(session.Token)
+				}
+			}
+		case <-h.quitCh:
+			return
+		}
+	}
+}
+
+type historyEntry struct {
+	seq     uint64
+	payload []byte
+	at      time.Time
+}
+
+// roomHistory keeps the recent messages of a room for replay. It is only
+// used from the room loop and needs no locking.
+type roomHistory struct {
+	entries   []historyEntry
+	size      int
+	retention time.Duration
+}
+
+func newRoomHistory(size int, retention time.Duration) *roomHistory {
+	return &roomHistory{size: size, retention: retention}
+}
+
+func (rh *roomHistory) append(seq uint64, payload []byte) {
+	now := time.Now()
+	rh.entries = append(rh.entries, historyEntry{seq: seq, payload: payload, at: now})
+	rh.prune(now)
+}
+
+func (rh *roomHistory) prune(now time.Time) {
+	drop := 0
+	for drop < len(rh.entries) && (len(rh.entries)-drop > rh.size || now.Sub(rh.entries[drop].at) > rh.retention) {
+		drop++
+	}
+	if drop > 0 {
+		rh.entries = append(rh.entries[:0:0], rh.entries[drop:]...)
+	}
+}
+
+// since returns the messages after seq. ok is false when some of them have
+// already been discarded and the client has to resync instead.
+func (rh *roomHistory) since(seq uint64, current uint64) (payloads [][]byte, ok bool) {
+	rh.prune(time.Now())
+	if seq == current {
+		return nil, true
+	}
+	if seq > current {
+		return nil, false
+	}
+	if len(rh.entries) == 0 || rh.entries[0].seq > seq+1 {
+		return nil, false
+	}
+	for _, entry := range rh.entries {
+		if entry.seq > seq {
+			payloads = append(payloads, entry.payload)
+		}
+	}
+	return payloads, true
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/issy20/go-websocket/apierror"
+	"github.com/issy20/go-websocket/auth"
+	"github.com/issy20/go-websocket/models"
+	"github.com/issy20/go-websocket/repository"
+)
+
+// MFAResponse answers a correct password when the user has 2FA enabled.
+// The token is exchanged for access and refresh tokens at /api/login/mfa.
+type MFAResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+type MFALoginInput struct {
+	MFAToken string `json:"mfa_token"`
+	// Code is the current TOTP code. RecoveryCode can be given instead.
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+	Cookie       bool   `json:"cookie"`
+}
+
+type TOTPEnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TOTPCodeInput struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+	Password     string `json:"password"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type ResetTOTPInput struct {
+	UserID string `json:"user_id"`
+}
+
+// writeMFAChallenge answers the first login step of a user with 2FA.
+func (api *API) writeMFAChallenge(w http.ResponseWriter, r *http.Request, user models.IUser) {
+	token, err := auth.CreateMFAToken(user)
+	if err != nil {
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&MFAResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int64(api.AuthConfig.MFATokenTTL.Seconds()),
+	})
+}
+
+// checkSecondFactor accepts a TOTP code, or a recovery code when no code is
+// given. Both work only once.
+func (api *API) checkSecondFactor(totp *models.TOTP, code string, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, ok := auth.ValidateTOTP(totp.Secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return api.TOTPRepository.UseStep(totp.UserID, step)
+	}
+	if recoveryCode != "" {
+		return api.TOTPRepository.UseRecoveryCode(totp.UserID, auth.HashRecoveryCode(recoveryCode))
+	}
+	return false, nil
+}
+
+// HandleLoginMFA finishes a login with the second factor.
+func (api *API) HandleLoginMFA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	var input MFALoginInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	userID, err := auth.ValidateMFAToken(input.MFAToken)
+	if err != nil {
+		apierror.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+	user := api.UserRepository.FindUserWithPasswordById(userID)
+	if user == nil {
+		apierror.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	ip := api.clientIP(r)
+	if wait := api.loginWait(user.Username, ip); wait > 0 {
+		writeRetryAfter(w, r, wait, http.StatusTooManyRequests)
+		return
+	}
+	totp, err := api.TOTPRepository.FindTOTP(user.Id)
+	if err != nil {
+		log.Println(err, "HandleLoginMFA()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	if totp == nil || !totp.Enabled {
+		apierror.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+	ok, err := api.checkSecondFactor(totp, input.Code, input.RecoveryCode)
+	if err != nil {
+		log.Println(err, "HandleLoginMFA()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		api.loginFailed(user.Username, user.Id, ip)
+		apierror.Write(w, r, http.StatusUnauthorized, "invalid code")
+		return
+	}
+	api.loginSucceeded(user.Username)
+	if input.Code == "" {
+		api.audit(&models.AuditEvent{Type: models.AuditRecoveryCode, UserID: user.Id, Username: user.Username, IP: ip})
+	}
+
+	sessionID := uuid.New().String()
+	if err := api.AuthSessionRepository.CreateSession(sessionID, user.Id); err != nil {
+		log.Println(err, "HandleLoginMFA()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	api.writeTokens(w, r, user, sessionID, input.Cookie)
+}
+
+// totpUser returns the registered user managing their own 2FA.
+func (api *API) totpUser(w http.ResponseWriter, r *http.Request) (*repository.User, bool) {
+	if r.Method != http.MethodPost {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	owner, ok := accountOwner(w, r)
+	if !ok {
+		return nil, false
+	}
+	user := api.UserRepository.FindUserWithPasswordById(owner.GetId())
+	if user == nil {
+		apierror.Status(w, r, http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
+
+// HandleEnrollTOTP starts enrolment and returns the secret to add to an
+// authenticator app. 2FA is only enabled once HandleEnableTOTP sees a code.
+func (api *API) HandleEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := api.totpUser(w, r)
+	if !ok {
+		return
+	}
+	totp, err := api.TOTPRepository.FindTOTP(user.Id)
+	if err != nil {
+		log.Println(err, "HandleEnrollTOTP()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	if totp != nil && totp.Enabled {
+		apierror.Write(w, r, http.StatusConflict, "2FA is already enabled")
+		return
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	if err := api.TOTPRepository.SetSecret(user.Id, secret); err != nil {
+		log.Println(err, "HandleEnrollTOTP()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&TOTPEnrollResponse{Secret: secret, URI: auth.TOTPURI(user.Username, secret)})
+}
+
+// HandleEnableTOTP enables 2FA with a first code from the authenticator and
+// returns the recovery codes, which are not shown again.
+func (api *API) HandleEnableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := api.totpUser(w, r)
+	if !ok {
+		return
+	}
+	var input TOTPCodeInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	totp, err := api.TOTPRepository.FindTOTP(user.Id)
+	if err != nil {
+		log.Println(err, "HandleEnableTOTP()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	if totp == nil || totp.Enabled {
+		apierror.Write(w, r, http.StatusConflict, "no 2FA enrolment in progress")
+		return
+	}
+	if ok, err := api.checkSecondFactor(totp, input.Code, ""); !ok || err != nil {
+		apierror.WriteField(w, r, "code", "invalid code")
+		return
+	}
+
+	codes, hashes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	if err := api.TOTPRepository.EnableTOTP(user.Id, hashes); err != nil {
+		log.Println(err, "HandleEnableTOTP()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	api.audit(&models.AuditEvent{Type: models.AuditTOTPEnabled, UserID: user.Id, Username: user.Username, IP: api.clientIP(r)})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// HandleDisableTOTP turns 2FA off after checking the password and a code or
+// recovery code.
+func (api *API) HandleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := api.totpUser(w, r)
+	if !ok {
+		return
+	}
+	var input TOTPCodeInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !api.confirmPassword(w, r, user, input.Password) {
+		return
+	}
+	totp, err := api.TOTPRepository.FindTOTP(user.Id)
+	if err != nil {
+		log.Println(err, "HandleDisableTOTP()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	if totp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if totp.Enabled {
+		if ok, err := api.checkSecondFactor(totp, input.Code, input.RecoveryCode); !ok || err != nil {
+			apierror.Write(w, r, http.StatusForbidden, "invalid code")
+			return
+		}
+	}
+	if err := api.TOTPRepository.DeleteTOTP(user.Id); err != nil {
+		log.Println(err, "HandleDisableTOTP()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	api.audit(&models.AuditEvent{Type: models.AuditTOTPDisabled, UserID: user.Id, Username: user.Username, IP: api.clientIP(r)})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleResetTOTP lets an admin remove the 2FA of a user who lost both the
+// authenticator and the recovery codes.
+func (api *API) HandleResetTOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	admin, ok := api.admin(w, r)
+	if !ok {
+		return
+	}
+	var input ResetTOTPInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	user := api.UserRepository.FindUserWithPasswordById(input.UserID)
+	if user == nil {
+		apierror.Status(w, r, http.StatusNotFound)
+		return
+	}
+	if err := api.TOTPRepository.DeleteTOTP(user.Id); err != nil {
+		log.Println(err, "HandleResetTOTP()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	api.audit(&models.AuditEvent{
+		Type:     models.AuditTOTPReset,
+		UserID:   user.Id,
+		Username: user.Username,
+		IP:       api.clientIP(r),
+		Detail:   fmt.Sprintf("reset by admin %s", admin.GetId()),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
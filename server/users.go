@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/issy20/go-websocket/apierror"
+	"github.com/issy20/go-websocket/auth"
+	"github.com/issy20/go-websocket/models"
+	"github.com/issy20/go-websocket/repository"
+)
+
+const (
+	defaultUsersPageSize = 50
+	maxUsersPageSize     = 200
+	maxNameLength        = 255
+	maxAvatarURLLength   = 1024
+)
+
+type UserResponse struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatar_url,omitempty"`
+	Bot       bool   `json:"bot,omitempty"`
+}
+
+type UsersResponse struct {
+	Users  []*UserResponse `json:"users"`
+	Limit  int             `json:"limit"`
+	Offset int             `json:"offset"`
+}
+
+// ProfileInput changes the fields that are set. An empty avatar_url removes
+// the avatar.
+type ProfileInput struct {
+	Name      *string `json:"name"`
+	AvatarURL *string `json:"avatar_url"`
+}
+
+func newUserResponse(user *repository.User) *UserResponse {
+	return &UserResponse{
+		ID:        user.Id,
+		Name:      user.Name,
+		AvatarURL: user.AvatarURL,
+		Bot:       user.Kind == models.UserKindService,
+	}
+}
+
+func queryInt(query url.Values, key string, fallback int) int {
+	value, err := strconv.Atoi(query.Get(key))
+	if err != nil || value < 0 {
+		return fallback
+	}
+	return value
+}
+
+// HandleUsers lists users on GET /api/users. ?q= searches names and
+// usernames, ?limit= and ?offset= page through the result.
+func (api *API) HandleUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	limit := queryInt(query, "limit", defaultUsersPageSize)
+	if limit == 0 || limit > maxUsersPageSize {
+		limit = maxUsersPageSize
+	}
+	offset := queryInt(query, "offset", 0)
+
+	users, err := api.UserRepository.FindUsers(query.Get("q"), limit, offset)
+	if err != nil {
+		log.Println(err, "HandleUsers()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	response := &UsersResponse{Users: make([]*UserResponse, 0, len(users)), Limit: limit, Offset: offset}
+	for _, user := range users {
+		response.Users = append(response.Users, newUserResponse(user))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// HandleUser serves /api/users/{id}. "me" names the authenticated user,
+// who can also update their profile with PATCH and delete their account
+// with DELETE.
+func (api *API) HandleUser(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/users/")
+	if id == "" || strings.Contains(id, "/") {
+		apierror.Status(w, r, http.StatusNotFound)
+		return
+	}
+	if id == "me" {
+		user, ok := r.Context().Value(auth.UserContextKey).(models.IUser)
+		if !ok {
+			apierror.Status(w, r, http.StatusUnauthorized)
+			return
+		}
+		id = user.GetId()
+		switch r.Method {
+		case http.MethodPatch:
+			api.updateProfile(w, r)
+			return
+		case http.MethodDelete:
+			api.deleteAccount(w, r)
+			return
+		}
+	}
+	if r.Method != http.MethodGet {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := api.UserRepository.FindProfileById(id)
+	if err != nil {
+		log.Println(err, "HandleUser()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		apierror.Status(w, r, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newUserResponse(user))
+}
+
+func validName(name string) bool {
+	return name != "" && utf8.RuneCountInString(name) <= maxNameLength
+}
+
+func validAvatarURL(avatarURL string) bool {
+	if avatarURL == "" {
+		return true
+	}
+	u, err := url.Parse(avatarURL)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "" && len(avatarURL) <= maxAvatarURLLength
+}
+
+// updateProfile changes the display name and avatar and tells every
+// connected client about the new name.
+func (api *API) updateProfile(w http.ResponseWriter, r *http.Request) {
+	owner, ok := accountOwner(w, r)
+	if !ok {
+		return
+	}
+	var input ProfileInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	user, err := api.UserRepository.FindProfileById(owner.GetId())
+	if err != nil || user == nil {
+		apierror.Status(w, r, http.StatusNotFound)
+		return
+	}
+
+	var fields []apierror.FieldError
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if !validName(name) {
+			fields = append(fields, apierror.FieldError{Field: "name", Message: "must be between 1 and 255 characters"})
+		}
+		user.Name = name
+	}
+	if input.AvatarURL != nil {
+		if !validAvatarURL(*input.AvatarURL) {
+			fields = append(fields, apierror.FieldError{Field: "avatar_url", Message: "must be an http or https URL"})
+		}
+		user.AvatarURL = *input.AvatarURL
+	}
+	if len(fields) > 0 {
+		apierror.WriteFields(w, r, fields)
+		return
+	}
+	if err := api.UserRepository.UpdateProfile(user.Id, user.Name, user.AvatarURL); err != nil {
+		log.Println(err, "updateProfile()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	api.Hub.PublishUserUpdated(&repository.User{Id: user.Id, Name: user.Name, AvatarURL: user.AvatarURL})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newUserResponse(user))
+}
+
+// deleteAccount removes the user together with their service accounts and
+// logs out every session.
+func (api *API) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	owner, ok := accountOwner(w, r)
+	if !ok {
+		return
+	}
+
+	accounts, err := api.UserRepository.FindServiceAccountsByOwnerId(owner.GetId())
+	if err != nil {
+		log.Println(err, "deleteAccount()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	for _, account := range accounts {
+		if err := api.APIKeyRepository.RevokeAPIKeysByUserId(account.Id); err != nil {
+			log.Println(err, "deleteAccount()")
+			apierror.Status(w, r, http.StatusInternalServerError)
+			return
+		}
+		api.UserRepository.RemoveUser(account)
+	}
+	if err := api.TOTPRepository.DeleteTOTP(owner.GetId()); err != nil {
+		log.Println(err, "deleteAccount()")
+	}
+	if err := api.UserIdentityRepository.RemoveIdentities(owner.GetId()); err != nil {
+		log.Println(err, "deleteAccount()")
+	}
+	api.revokeUserSessions(owner, "")
+	api.UserRepository.RemoveUser(owner)
+	auth.ClearTokenCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
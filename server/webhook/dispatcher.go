@@ -0,0 +1,403 @@
+// Package webhook delivers chat events to the HTTP endpoints admins
+// registered, signing each payload and retrying failed deliveries, and
+// rate limits the incoming webhooks that post into rooms.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/issy20/go-websocket/config"
+	"github.com/issy20/go-websocket/models"
+)
+
+// Headers of a delivery request. SignatureHeader is "t=<unix time>,v1=<hex>"
+// where the hex is the HMAC-SHA256 of "<unix time>.<body>" keyed with the
+// webhook's secret; see Sign.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// maxResponseSize is how much of a response body is read before the
+// connection is given back.
+const maxResponseSize = 64 << 10
+
+// resumeBatch is how many overdue deliveries are resumed at a time.
+const resumeBatch = 100
+
+// Event is the body of every delivery.
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+type emitted struct {
+	event   string
+	payload []byte
+}
+
+type job struct {
+	hook     *models.Webhook
+	delivery *models.WebhookDelivery
+}
+
+// Dispatcher records a delivery for every webhook subscribed to an event
+// and sends them from a pool of workers. Failed deliveries are retried with
+// exponential backoff until they succeed or become dead letters. Retries
+// are timers in memory, so every Refresh the dispatcher also resumes pending
+// deliveries whose retry is overdue, such as those left by a node that
+// stopped. Deliveries are therefore sent at least once, not exactly once.
+type Dispatcher struct {
+	repository models.WebhookRepository
+	config     *config.WebhookConfig
+	client     *http.Client
+
+	mu    sync.RWMutex
+	hooks []*models.Webhook
+
+	eventCh  chan emitted
+	jobCh    chan *job
+	quitCh   chan struct{}
+	quitOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func NewDispatcher(repository models.WebhookRepository, c *config.WebhookConfig) *Dispatcher {
+	return &Dispatcher{
+		repository: repository,
+		config:     c,
+		client: &http.Client{
+			Timeout: c.Timeout,
+			// A redirect counts as a failed delivery rather than sending
+			// the signed payload somewhere else.
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		eventCh: make(chan emitted, c.QueueSize),
+		jobCh:   make(chan *job),
+		quitCh:  make(chan struct{}),
+	}
+}
+
+// NewSecret returns a random secret to sign a webhook's deliveries with.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with secret.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Reload reads the webhooks from the repository.
+func (d *Dispatcher) Reload() error {
+	hooks, err := d.repository.FindWebhooks()
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.hooks = hooks
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *Dispatcher) subscribers(event string) []*models.Webhook {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var hooks []*models.Webhook
+	for _, hook := range d.hooks {
+		if hook.Subscribes(event) {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+
+func (d *Dispatcher) hook(id string) *models.Webhook {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, hook := range d.hooks {
+		if hook.ID == id {
+			return hook
+		}
+	}
+	return nil
+}
+
+// Emit queues event for the webhooks subscribed to it. data is encoded
+// right away, so callers may change it afterwards. Emit never blocks; when
+// the queue is full the event is dropped.
+func (d *Dispatcher) Emit(event string, data interface{}) {
+	if len(d.subscribers(event)) == 0 {
+		return
+	}
+	payload, err := json.Marshal(&Event{ID: uuid.New().String(), Type: event, CreatedAt: time.Now(), Data: data})
+	if err != nil {
+		log.Println(err, "Emit()")
+		return
+	}
+	select {
+	case d.eventCh <- emitted{event: event, payload: payload}:
+	default:
+		log.Printf("webhook: queue full, dropping %s event", event)
+	}
+}
+
+// Redeliver sends a dead delivery again with a fresh set of attempts.
+func (d *Dispatcher) Redeliver(delivery *models.WebhookDelivery) error {
+	hook := d.hook(delivery.WebhookID)
+	if hook == nil {
+		return models.ErrWebhookNotFound
+	}
+	delivery.State = models.DeliveryPending
+	delivery.Attempts = 0
+	delivery.UpdatedAt = time.Now()
+	if err := d.repository.UpdateDelivery(delivery); err != nil {
+		return err
+	}
+	go d.enqueue(&job{hook: hook, delivery: delivery})
+	return nil
+}
+
+// Run loads the webhooks, resumes overdue deliveries and delivers events
+// until Shutdown.
+func (d *Dispatcher) Run() {
+	if err := d.Reload(); err != nil {
+		log.Println(err, "webhook Run()")
+	}
+	for i := 0; i < d.config.Workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	d.resume()
+
+	ticker := time.NewTicker(d.config.Refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case e := <-d.eventCh:
+			d.record(e)
+		case <-ticker.C:
+			if err := d.Reload(); err != nil {
+				log.Println(err, "webhook Run()")
+			}
+			d.resume()
+		case <-d.quitCh:
+			return
+		}
+	}
+}
+
+// Shutdown stops the dispatcher and waits for deliveries in flight.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	d.quitOnce.Do(func() { close(d.quitCh) })
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// record stores a pending delivery of e for each subscribed webhook.
+func (d *Dispatcher) record(e emitted) {
+	for _, hook := range d.subscribers(e.event) {
+		now := time.Now()
+		delivery := &models.WebhookDelivery{
+			ID:        uuid.New().String(),
+			WebhookID: hook.ID,
+			Event:     e.event,
+			Payload:   e.payload,
+			State:     models.DeliveryPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if err := d.repository.AddDelivery(delivery); err != nil {
+			log.Println(err, "webhook record()")
+			continue
+		}
+		if !d.enqueue(&job{hook: hook, delivery: delivery}) {
+			return
+		}
+	}
+}
+
+// enqueue hands j to a worker and reports false once the dispatcher stops.
+func (d *Dispatcher) enqueue(j *job) bool {
+	select {
+	case d.jobCh <- j:
+		return true
+	case <-d.quitCh:
+		return false
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for {
+		select {
+		case j := <-d.jobCh:
+			d.attempt(j)
+		case <-d.quitCh:
+			return
+		}
+	}
+}
+
+// attempt sends a delivery once and records the outcome. Failures are
+// retried after the backoff unless the attempts are used up.
+func (d *Dispatcher) attempt(j *job) {
+	delivery := j.delivery
+	status, err := d.send(j.hook, delivery)
+	delivery.Attempts++
+	delivery.LastStatus = status
+	delivery.LastError = ""
+	delivery.UpdatedAt = time.Now()
+	switch {
+	case err == nil:
+		delivery.State = models.DeliveryDelivered
+	case delivery.Attempts >= d.config.MaxAttempts:
+		delivery.State = models.DeliveryDead
+		delivery.LastError = err.Error()
+	default:
+		delivery.LastError = err.Error()
+	}
+	if err := d.repository.UpdateDelivery(delivery); err != nil {
+		log.Println(err, "webhook attempt()")
+	}
+	// The retry is armed only now, since it hands delivery to another worker.
+	if delivery.State == models.DeliveryPending {
+		time.AfterFunc(d.backoff(delivery.Attempts), func() {
+			d.retry(j)
+		})
+	}
+}
+
+// retry enqueues j again, giving up when its webhook was deleted meanwhile.
+func (d *Dispatcher) retry(j *job) {
+	if d.hook(j.hook.ID) == nil {
+		d.bury(j.delivery, models.ErrWebhookNotFound)
+		return
+	}
+	d.enqueue(j)
+}
+
+// bury makes delivery a dead letter without sending it again.
+func (d *Dispatcher) bury(delivery *models.WebhookDelivery, err error) {
+	delivery.State = models.DeliveryDead
+	delivery.LastError = err.Error()
+	delivery.UpdatedAt = time.Now()
+	if err := d.repository.UpdateDelivery(delivery); err != nil {
+		log.Println(err, "webhook bury()")
+	}
+}
+
+// resume claims pending deliveries whose next attempt is overdue and hands
+// them to the workers. A delivery is overdue once its backoff, the request
+// timeout and a Refresh have passed since its last attempt, so that those
+// still retried by a running node are left alone. Claiming keeps two nodes
+// from resuming the same delivery.
+func (d *Dispatcher) resume() {
+	now := time.Now()
+	deliveries, err := d.repository.FindPendingDeliveries(now.Add(-d.config.Backoff), resumeBatch)
+	if err != nil {
+		log.Println(err, "webhook resume()")
+		return
+	}
+	var jobs []*job
+	for _, delivery := range deliveries {
+		if now.Before(delivery.UpdatedAt.Add(d.backoff(delivery.Attempts) + d.config.Timeout + d.config.Refresh)) {
+			continue
+		}
+		claimed, err := d.repository.ClaimDelivery(delivery, now)
+		if err != nil {
+			log.Println(err, "webhook resume()")
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		hook := d.hook(delivery.WebhookID)
+		if hook == nil {
+			d.bury(delivery, models.ErrWebhookNotFound)
+			continue
+		}
+		jobs = append(jobs, &job{hook: hook, delivery: delivery})
+	}
+	// Handing over waits for free workers, which must not hold up Run.
+	go func() {
+		for _, j := range jobs {
+			if !d.enqueue(j) {
+				return
+			}
+		}
+	}()
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.config.Backoff
+	for i := 1; i < attempts && wait < d.config.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > d.config.MaxBackoff {
+		wait = d.config.MaxBackoff
+	}
+	return wait
+}
+
+// send posts the delivery and returns the response status. Anything but a
+// 2xx status is an error.
+func (d *Dispatcher) send(hook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(SignatureHeader, fmt.Sprintf("t=%d,v1=%s", timestamp, Sign(hook.Secret, timestamp, delivery.Payload)))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, maxResponseSize))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
@@ -0,0 +1,169 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/issy20/go-websocket/config"
+	"github.com/issy20/go-websocket/models"
+)
+
+// fakeRepository keeps webhooks and deliveries in memory.
+type fakeRepository struct {
+	mu         sync.Mutex
+	hooks      []*models.Webhook
+	deliveries map[string]models.WebhookDelivery
+}
+
+func (r *fakeRepository) AddWebhook(hook *models.Webhook) error { return nil }
+
+func (r *fakeRepository) FindWebhooks() ([]*models.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hooks, nil
+}
+
+func (r *fakeRepository) DeleteWebhook(id string) error { return nil }
+
+func (r *fakeRepository) AddDelivery(delivery *models.WebhookDelivery) error {
+	return r.UpdateDelivery(delivery)
+}
+
+func (r *fakeRepository) UpdateDelivery(delivery *models.WebhookDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deliveries[delivery.ID] = *delivery
+	return nil
+}
+
+func (r *fakeRepository) FindDelivery(id string) (*models.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delivery, ok := r.deliveries[id]
+	if !ok {
+		return nil, nil
+	}
+	return &delivery, nil
+}
+
+func (r *fakeRepository) FindDeliveries(webhookID string, state string, limit int) ([]*models.WebhookDelivery, error) {
+	return nil, nil
+}
+
+func (r *fakeRepository) FindPendingDeliveries(updatedBefore time.Time, limit int) ([]*models.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var deliveries []*models.WebhookDelivery
+	for _, delivery := range r.deliveries {
+		if delivery.State == models.DeliveryPending && delivery.UpdatedAt.Before(updatedBefore) {
+			delivery := delivery
+			deliveries = append(deliveries, &delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+func (r *fakeRepository) ClaimDelivery(delivery *models.WebhookDelivery, now time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.deliveries[delivery.ID]
+	if !ok || stored.State != models.DeliveryPending || !stored.UpdatedAt.Equal(delivery.UpdatedAt) {
+		return false, nil
+	}
+	stored.UpdatedAt = now
+	r.deliveries[delivery.ID] = stored
+	delivery.UpdatedAt = now
+	return true, nil
+}
+
+func (r *fakeRepository) state(id string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.deliveries[id].State
+}
+
+func testConfig() *config.WebhookConfig {
+	return &config.WebhookConfig{
+		MaxAttempts: 3,
+		Backoff:     time.Second,
+		MaxBackoff:  time.Minute,
+		Timeout:     5 * time.Second,
+		Workers:     2,
+		QueueSize:   10,
+		Refresh:     time.Minute,
+	}
+}
+
+// TestDispatcherResumesOverdueDeliveries starts two dispatchers on a
+// repository holding deliveries left pending by a stopped node.
+func TestDispatcherResumesOverdueDeliveries(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received[r.Header.Get(DeliveryHeader)]++
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	pending := func(id string, hookID string, attempts int, updatedAt time.Time) models.WebhookDelivery {
+		return models.WebhookDelivery{
+			ID: id, WebhookID: hookID, Event: models.EventMessageCreated, Payload: []byte(`{}`),
+			State: models.DeliveryPending, Attempts: attempts, CreatedAt: updatedAt, UpdatedAt: updatedAt,
+		}
+	}
+	repository := &fakeRepository{
+		hooks: []*models.Webhook{{ID: "hook", URL: server.URL, Events: []string{models.EventMessageCreated}, Secret: "secret"}},
+		deliveries: map[string]models.WebhookDelivery{
+			"overdue":     pending("overdue", "hook", 2, now.Add(-time.Hour)),
+			"new":         pending("new", "hook", 0, now.Add(-time.Hour)),
+			"retried":     pending("retried", "hook", 2, now.Add(-time.Minute)),
+			"deleted":     pending("deleted", "gone", 1, now.Add(-time.Hour)),
+			"in progress": pending("in progress", "hook", 0, now),
+		},
+	}
+
+	dispatchers := []*Dispatcher{NewDispatcher(repository, testConfig()), NewDispatcher(repository, testConfig())}
+	for _, d := range dispatchers {
+		go d.Run()
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		for _, d := range dispatchers {
+			if err := d.Shutdown(ctx); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for repository.state("overdue") != models.DeliveryDelivered || repository.state("new") != models.DeliveryDelivered {
+		if time.Now().After(deadline) {
+			t.Fatal("overdue deliveries were not resumed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, id := range []string{"overdue", "new"} {
+		if received[id] != 1 {
+			t.Errorf("%s was sent %d times, want once", id, received[id])
+		}
+	}
+	// Their next attempts are due within the backoff, timeout and refresh.
+	for _, id := range []string{"retried", "in progress"} {
+		if received[id] != 0 || repository.state(id) != models.DeliveryPending {
+			t.Errorf("%s was resumed before it was overdue", id)
+		}
+	}
+	if state := repository.state("deleted"); state != models.DeliveryDead {
+		t.Errorf("delivery of a deleted webhook is %s, want dead", state)
+	}
+}
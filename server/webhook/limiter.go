@@ -0,0 +1,72 @@
+package webhook
+
+import (
+	"sync"
+	"time"
+)
+
+const limiterPruneInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter lets each key, such as an incoming webhook, act limit times
+// per interval with bursts of up to burst. State is kept per node.
+type RateLimiter struct {
+	// perToken is the time it takes to earn one token back.
+	perToken time.Duration
+	burst    float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+func NewRateLimiter(limit int, interval time.Duration, burst int) *RateLimiter {
+	return &RateLimiter{
+		perToken: interval / time.Duration(limit),
+		burst:    float64(burst),
+		buckets:  make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token of key and returns zero, or returns how long key has
+// to wait for its next token.
+func (l *RateLimiter) Allow(key string) time.Duration {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += float64(now.Sub(b.last)) / float64(l.perToken)
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) * float64(l.perToken))
+	}
+	b.tokens--
+	return 0
+}
+
+// prune forgets keys whose bucket has filled up again, as a new bucket
+// starts full anyway.
+func (l *RateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < limiterPruneInterval {
+		return
+	}
+	l.lastPrune = now
+	for key, b := range l.buckets {
+		if b.tokens+float64(now.Sub(b.last))/float64(l.perToken) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
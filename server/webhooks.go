@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/issy20/go-websocket/apierror"
+	"github.com/issy20/go-websocket/models"
+	"github.com/issy20/go-websocket/webhook"
+)
+
+const (
+	maxWebhookURLLength       = 2048
+	defaultDeliveriesPageSize = 50
+	maxDeliveriesPageSize     = 200
+)
+
+type WebhookInput struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+type WebhookResponse struct {
+	*models.Webhook
+	// Secret signs the deliveries. It is only returned when the webhook is
+	// created.
+	Secret string `json:"secret"`
+}
+
+type WebhookIDInput struct {
+	ID string `json:"id"`
+}
+
+func validateWebhookInput(input *WebhookInput) []apierror.FieldError {
+	var fields []apierror.FieldError
+	u, err := url.Parse(input.URL)
+	switch {
+	case input.URL == "" || len(input.URL) > maxWebhookURLLength:
+		fields = append(fields, apierror.FieldError{Field: "url", Message: fmt.Sprintf("must be 1 to %d characters", maxWebhookURLLength)})
+	case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+		fields = append(fields, apierror.FieldError{Field: "url", Message: "must be an absolute http or https URL"})
+	}
+	if len(input.Events) == 0 {
+		fields = append(fields, apierror.FieldError{Field: "events", Message: "must name at least one event"})
+	}
+	for _, event := range input.Events {
+		if !models.ValidEvent(event) {
+			fields = append(fields, apierror.FieldError{Field: "events", Message: fmt.Sprintf("unknown event %q", event)})
+		}
+	}
+	return fields
+}
+
+// HandleWebhooks lists the webhooks on GET and registers one on POST.
+// Admins only.
+func (api *API) HandleWebhooks(w http.ResponseWriter, r *http.Request) {
+	admin, ok := api.admin(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		hooks, err := api.WebhookRepository.FindWebhooks()
+		if err != nil {
+			log.Println(err, "HandleWebhooks()")
+			apierror.Status(w, r, http.StatusInternalServerError)
+			return
+		}
+		if hooks == nil {
+			hooks = []*models.Webhook{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hooks)
+
+	case http.MethodPost:
+		var input WebhookInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if fields := validateWebhookInput(&input); len(fields) > 0 {
+			apierror.WriteFields(w, r, fields)
+			return
+		}
+		secret, err := webhook.NewSecret()
+		if err != nil {
+			log.Println(err, "HandleWebhooks()")
+			apierror.Status(w, r, http.StatusInternalServerError)
+			return
+		}
+		hook := &models.Webhook{
+			ID:        uuid.New().String(),
+			URL:       input.URL,
+			Events:    dedupe(input.Events),
+			Secret:    secret,
+			CreatedBy: admin.GetId(),
+			CreatedAt: time.Now(),
+		}
+		if err := api.WebhookRepository.AddWebhook(hook); err != nil {
+			log.Println(err, "HandleWebhooks()")
+			apierror.Status(w, r, http.StatusInternalServerError)
+			return
+		}
+		api.reloadWebhooks()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(&WebhookResponse{Webhook: hook, Secret: secret})
+
+	default:
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleDeleteWebhook removes a webhook. Its deliveries stay in the log;
+// pending ones are given up.
+func (api *API) HandleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := api.admin(w, r); !ok {
+		return
+	}
+	var input WebhookIDInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := api.WebhookRepository.DeleteWebhook(input.ID); err != nil {
+		if errors.Is(err, models.ErrWebhookNotFound) {
+			apierror.Status(w, r, http.StatusNotFound)
+			return
+		}
+		log.Println(err, "HandleDeleteWebhook()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	api.reloadWebhooks()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleWebhookDeliveries lists the delivery log, newest first.
+// ?webhook_id= and ?state= filter it and ?limit= caps it.
+func (api *API) HandleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := api.admin(w, r); !ok {
+		return
+	}
+	query := r.URL.Query()
+	limit := queryInt(query, "limit", defaultDeliveriesPageSize)
+	if limit == 0 || limit > maxDeliveriesPageSize {
+		limit = maxDeliveriesPageSize
+	}
+	deliveries, err := api.WebhookRepository.FindDeliveries(query.Get("webhook_id"), query.Get("state"), limit)
+	if err != nil {
+		log.Println(err, "HandleWebhookDeliveries()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// HandleRedeliverWebhook sends a delivery again, typically a dead letter.
+// Deliveries still pending are being retried already.
+func (api *API) HandleRedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Status(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := api.admin(w, r); !ok {
+		return
+	}
+	var input WebhookIDInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	delivery, err := api.WebhookRepository.FindDelivery(input.ID)
+	if err != nil {
+		log.Println(err, "HandleRedeliverWebhook()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	if delivery == nil {
+		apierror.Status(w, r, http.StatusNotFound)
+		return
+	}
+	if delivery.State == models.DeliveryPending {
+		apierror.Write(w, r, http.StatusConflict, "delivery is still pending")
+		return
+	}
+	if err := api.Webhooks.Redeliver(delivery); err != nil {
+		if errors.Is(err, models.ErrWebhookNotFound) {
+			apierror.Write(w, r, http.StatusNotFound, err.Error())
+			return
+		}
+		log.Println(err, "HandleRedeliverWebhook()")
+		apierror.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// reloadWebhooks applies a change on this node right away; other nodes pick
+// it up on their next refresh.
+func (api *API) reloadWebhooks() {
+	if err := api.Webhooks.Reload(); err != nil {
+		log.Println(err, "reloadWebhooks()")
+	}
+}
+
+func dedupe(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestValidateWebhookInputEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []string
+		valid  bool
+	}{
+		{"emitted events", []string{"message.created", "user.joined", "user.left", "room.created"}, true},
+		{"none", nil, false},
+		{"never emitted", []string{"member.banned"}, false},
+		{"unknown", []string{"message.deleted"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := validateWebhookInput(&WebhookInput{URL: "https://example.com/hook", Events: tt.events})
+			if valid := len(fields) == 0; valid != tt.valid {
+				t.Fatalf("got %v, want valid %v", fields, tt.valid)
+			}
+		})
+	}
+}